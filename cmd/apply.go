@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+	"path"
+	"strings"
+)
+
+func init() {
+	ApplyCmd.Flags().StringVarP(&applyCmdParams.planFile, "plan", "p", "", "Plan file in JSON format, generated with --output json")
+	ApplyCmd.Flags().BoolVar(&applyCmdParams.dryRun, "dry-run", false, "Only verify the plan without executing it")
+	ApplyCmd.Flags().BoolVar(&applyCmdParams.allowExec, "allow-exec", false, "Allow the exec actions of the plan to run their commands")
+}
+
+type applyCmdParamsType struct {
+	planFile string
+	dryRun   bool
+	// allowExec enables the exec actions, since a plan can be edited to run any command
+	allowExec bool
+}
+
+// archiveGroup identifies the files of a plan bundled in the same archive
+type archiveGroup struct {
+	root     string
+	destDir  string
+	format   string
+	trashDir string
+}
+
+var applyCmdParams = applyCmdParamsType{}
+
+var ApplyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "execute the actions of a saved plan",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return applyPlan(applyCmdParams)
+	},
+}
+
+func applyPlan(params applyCmdParamsType) error {
+	if len(params.planFile) == 0 {
+		log.Errorln("Missing plan file")
+		return errors.New("missing plan file")
+	}
+	plan, err := readPlan(params.planFile)
+	if err != nil {
+		log.Errorln("Invalid plan file", err)
+		return err
+	}
+	if !plan.DryRun {
+		log.Warnln("Plan generated by a real run, its actions were already executed")
+	}
+
+	log.Infof("Applying %d actions of %v plan generated at %v", len(plan.Entries), plan.Command, plan.GeneratedAt.Format("2006-01-02 15:04:05"))
+	var applied, skipped, failed int
+	archives := map[archiveGroup][]cleanCandidate{}
+	var archiveOrder []archiveGroup
+
+	for _, entry := range plan.Entries {
+		fileInfo, ok := verifyPlanEntry(entry)
+		if !ok {
+			skipped++
+			continue
+		}
+
+		action := strings.ToUpper(entry.Action)
+		if action == "ARCHIVE" {
+			group := archiveGroup{root: entry.Root, destDir: entry.Destination, format: entry.Options["format"], trashDir: entry.Options["trashDir"]}
+			if _, ok := archives[group]; !ok {
+				archiveOrder = append(archiveOrder, group)
+			}
+			candidate := cleanCandidate{rootDir: entry.Root, dirName: path.Dir(entry.Path), fileInfo: fileInfo, fileTime: fileInfo.ModTime(), reason: entry.Rule}
+			archives[group] = append(archives[group], candidate)
+			continue
+		}
+
+		if action == "EXEC" && !params.allowExec {
+			log.Errorf("Refusing to execute command %v on file %v, exec actions of a plan require --allow-exec", entry.Options["command"], entry.Path)
+			failed++
+			continue
+		}
+		if params.dryRun {
+			log.Infof("Verified %v %v", action, entry.Path)
+			applied++
+			continue
+		}
+		err := applyPlanEntry(action, entry)
+		if errors.Is(err, errFileSkipped) {
+			skipped++
+			continue
+		}
+		if err != nil {
+			log.Errorf("Error applying %v to file %v: %v", action, entry.Path, err.Error())
+			failed++
+			continue
+		}
+		applied++
+	}
+
+	for _, group := range archiveOrder {
+		candidates := archives[group]
+		if params.dryRun {
+			log.Infof("Verified ARCHIVE of %d files of directory %v into %v", len(candidates), group.root, group.destDir)
+			applied += len(candidates)
+			continue
+		}
+		archived, err := applyArchiveGroup(group, candidates)
+		applied += archived
+		if err != nil {
+			log.Errorf("Error archiving files of directory %v: %v", group.root, err.Error())
+			failed += len(candidates) - archived
+		}
+	}
+
+	log.Infof("Plan applied: %d actions executed, %d skipped, %d failed", applied, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d actions failed", failed)
+	}
+	return nil
+}
+
+// verifyPlanEntry checks that the file still has the size and modification time
+// recorded in the plan, returning its current info
+func verifyPlanEntry(entry planEntry) (os.FileInfo, bool) {
+	fileInfo, err := os.Lstat(entry.Path)
+	if err != nil {
+		log.Warnln("Skipping file", entry.Path, "no longer available:", err.Error())
+		return nil, false
+	}
+	if !fileInfo.Mode().IsRegular() {
+		log.Warnln("Skipping file", entry.Path, "not a regular file")
+		return nil, false
+	}
+	if fileInfo.Size() != entry.Size || !fileInfo.ModTime().Equal(entry.ModTime) {
+		log.Warnf("Skipping file %v changed since the plan was generated (%d bytes, modified %v)", entry.Path, fileInfo.Size(), fileInfo.ModTime())
+		return nil, false
+	}
+	return fileInfo, true
+}
+
+func applyPlanEntry(action string, entry planEntry) error {
+	opts := processOptions{rule: entry.Rule, conflict: entry.Options["conflict"]}
+	var destDir string
+	switch action {
+	case "DELETE":
+		log.Infof("Deleting file %v", entry.Path)
+		return deleteFile(entry.Path)
+	case "TRASH":
+		log.Infof("Trashing file %v", entry.Path)
+		return trashFile(entry.Destination, entry.Path, entry.Rule)
+	case "COPY", "COPY-DELETE", "MOVE":
+		opts.destName = path.Base(entry.Destination)
+		opts.verify = entry.Options["verify"]
+		opts.checksumFile = entry.Options["checksumFile"] == "true"
+		if len(entry.Options["preserve"]) > 0 {
+			preserve, err := newPreserveOptions(strings.Split(entry.Options["preserve"], ","))
+			if err != nil {
+				return err
+			}
+			opts.preserve = preserve
+		}
+		destDir = path.Dir(entry.Destination)
+	case "COMPRESS":
+		opts.compression = entry.Options["compression"]
+		opts.destName = strings.TrimSuffix(path.Base(entry.Destination), compressedExt(opts.compression))
+		destDir = path.Dir(entry.Destination)
+	case "EXTRACT":
+		maxExtractBytes, err := parseMaxExtractSize(entry.Options["maxExtractSize"])
+		if err != nil {
+			return err
+		}
+		opts.maxExtractBytes = maxExtractBytes
+		destDir = entry.Destination
+	case "LINK", "HARDLINK":
+		opts.destName = path.Base(entry.Destination)
+		opts.latestLink = entry.Options["latestLink"]
+		destDir = path.Dir(entry.Destination)
+	case "RENAME":
+		opts.destName = path.Base(entry.Destination)
+	case "EXEC":
+		opts.trashDir = entry.Options["trashDir"]
+		opts.compression = entry.Options["compression"]
+		execOpts, err := execPlanOptions(entry.Options)
+		if err != nil {
+			return err
+		}
+		log.Infof("Running command line %q of the plan on file %v", execOpts.command, entry.Path)
+		opts.exec = execOpts
+	case "PERMISSIONS":
+	default:
+		return fmt.Errorf("unsupported action %v", action)
+	}
+
+	permissions, err := newPermissionOptions(entry.Options["mode"], entry.Options["owner"], entry.Options["group"])
+	if err != nil {
+		return err
+	}
+	opts.permissions = permissions
+	return processFile(action, path.Dir(entry.Path), destDir, path.Base(entry.Path), opts)
+}
+
+// applyArchiveGroup archives the files and then deletes (or trashes) them,
+// returning the number of files completely processed
+func applyArchiveGroup(group archiveGroup, candidates []cleanCandidate) (int, error) {
+	archivePath, err := archiveFiles(group.root, candidates, group.destDir, group.format)
+	if err != nil {
+		return 0, err
+	}
+	log.Infof("Archived %d files of directory %v into %v", len(candidates), group.root, archivePath)
+
+	for i, candidate := range candidates {
+		filePath := path.Join(candidate.dirName, candidate.fileInfo.Name())
+		if len(group.trashDir) > 0 {
+			log.Infof("Trashing file %v", filePath)
+			err = trashFile(group.trashDir, filePath, candidate.reason)
+		} else {
+			log.Infof("Deleting file %v", filePath)
+			err = deleteFile(filePath)
+		}
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(candidates), nil
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestVerifyPlanEntry(t *testing.T) {
+	modTime := time.Date(2024, time.March, 5, 14, 30, 0, 0, time.Local)
+	tests := []struct {
+		name string
+		// prepare changes the planned file before the verification
+		prepare func(t *testing.T, filePath string)
+		size    int64
+		modTime time.Time
+		want    bool
+	}{
+		{name: "unchanged", size: 7, modTime: modTime, want: true},
+		{name: "size changed", size: 8, modTime: modTime},
+		{name: "modification time changed", size: 7, modTime: modTime.Add(time.Second)},
+		{name: "removed", size: 7, modTime: modTime, prepare: func(t *testing.T, filePath string) {
+			if err := os.Remove(filePath); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{name: "replaced by a symlink", size: 7, modTime: modTime, prepare: func(t *testing.T, filePath string) {
+			target := filePath + ".target"
+			if err := os.Rename(filePath, target); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink(target, filePath); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{name: "replaced by a directory", size: 7, modTime: modTime, prepare: func(t *testing.T, filePath string) {
+			if err := os.Remove(filePath); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Mkdir(filePath, 0755); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filePath := path.Join(t.TempDir(), "report.csv")
+			writeTestFile(t, filePath, "content", modTime)
+			if test.prepare != nil {
+				test.prepare(t, filePath)
+			}
+			entry := planEntry{Path: filePath, Size: test.size, ModTime: test.modTime, Action: "DELETE"}
+			fileInfo, ok := verifyPlanEntry(entry)
+			if ok != test.want {
+				t.Fatalf("verifyPlanEntry() = %v, want %v", ok, test.want)
+			}
+			if ok && fileInfo.Name() != "report.csv" {
+				t.Errorf("verifyPlanEntry() file %v, want report.csv", fileInfo.Name())
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type archiveManifestEntry struct {
+	Path    string    `json:"path"`
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	SHA256  string    `json:"sha256"`
+}
+
+type archiveManifest struct {
+	Archive   string                 `json:"archive"`
+	Directory string                 `json:"directory"`
+	CreatedAt time.Time              `json:"createdAt"`
+	Files     []archiveManifestEntry `json:"files"`
+}
+
+// archiveFiles bundles the candidates in a dated archive inside archiveDir,
+// verifies its content and writes a manifest of the archived files next to it.
+// The source files are left untouched.
+func archiveFiles(rootDir string, candidates []cleanCandidate, archiveDir, format string) (string, error) {
+	archivePath, archiveFile, err := createArchiveFile(rootDir, archiveDir, format)
+	if err != nil {
+		return "", err
+	}
+
+	manifest := archiveManifest{
+		Archive:   archivePath,
+		Directory: rootDir,
+		CreatedAt: time.Now(),
+		Files:     make([]archiveManifestEntry, 0, len(candidates)),
+	}
+
+	switch format {
+	case "zip":
+		err = writeZipArchive(archiveFile, rootDir, candidates, &manifest)
+	default:
+		err = writeTarGzArchive(archiveFile, rootDir, candidates, &manifest)
+	}
+	// The archive must be on disk, not only in the page cache, before the originals are deleted
+	if err == nil {
+		err = archiveFile.Sync()
+	}
+	if closeErr := archiveFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = verifyArchive(archivePath, format, manifest)
+	}
+	if err == nil {
+		err = writeArchiveManifest(archivePath+".manifest.json", manifest)
+	}
+	if err == nil {
+		err = syncDir(archiveDir)
+	}
+	if err != nil {
+		log.Errorln("Error creating archive", archivePath, err.Error())
+		if err := os.Remove(archivePath); err != nil {
+			log.Warnln("Error removing incomplete archive", archivePath, err.Error())
+		}
+		return "", err
+	}
+	return archivePath, nil
+}
+
+func createArchiveFile(rootDir, archiveDir, format string) (string, *os.File, error) {
+	baseName := filepath.Base(filepath.Clean(rootDir)) + "-" + time.Now().Format("20060102-150405")
+	archivePath := path.Join(archiveDir, baseName+"."+format)
+	for i := 1; ; i++ {
+		archiveFile, err := os.OpenFile(archivePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			return archivePath, archiveFile, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return "", nil, err
+		}
+		archivePath = path.Join(archiveDir, fmt.Sprintf("%v-%d.%v", baseName, i, format))
+	}
+}
+
+func archiveEntryName(rootDir string, candidate cleanCandidate) string {
+	filePath := path.Join(candidate.dirName, candidate.fileInfo.Name())
+	name, err := filepath.Rel(rootDir, filePath)
+	if err != nil {
+		return candidate.fileInfo.Name()
+	}
+	return filepath.ToSlash(name)
+}
+
+func writeTarGzArchive(out io.Writer, rootDir string, candidates []cleanCandidate, manifest *archiveManifest) error {
+	gzipWriter := gzip.NewWriter(out)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, candidate := range candidates {
+		header, err := tar.FileInfoHeader(candidate.fileInfo, "")
+		if err != nil {
+			return err
+		}
+		header.Name = archiveEntryName(rootDir, candidate)
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if err := addArchiveEntry(tarWriter, header.Name, candidate, manifest); err != nil {
+			return err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+func writeZipArchive(out io.Writer, rootDir string, candidates []cleanCandidate, manifest *archiveManifest) error {
+	zipWriter := zip.NewWriter(out)
+	for _, candidate := range candidates {
+		header, err := zip.FileInfoHeader(candidate.fileInfo)
+		if err != nil {
+			return err
+		}
+		header.Name = archiveEntryName(rootDir, candidate)
+		header.Method = zip.Deflate
+		entryWriter, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if err := addArchiveEntry(entryWriter, header.Name, candidate, manifest); err != nil {
+			return err
+		}
+	}
+	return zipWriter.Close()
+}
+
+func addArchiveEntry(out io.Writer, name string, candidate cleanCandidate, manifest *archiveManifest) error {
+	filePath := path.Join(candidate.dirName, candidate.fileInfo.Name())
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Warnln("Error closing source file", filePath, err.Error())
+		}
+	}(file)
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(out, hash), file)
+	if err != nil {
+		return err
+	}
+	if written != candidate.fileInfo.Size() {
+		return fmt.Errorf("file %v changed while archiving", filePath)
+	}
+
+	manifest.Files = append(manifest.Files, archiveManifestEntry{
+		Path:    filePath,
+		Name:    name,
+		Size:    written,
+		ModTime: candidate.fileInfo.ModTime(),
+		SHA256:  hex.EncodeToString(hash.Sum(nil)),
+	})
+	return nil
+}
+
+// verifyArchive reads back the whole archive checking that every file of the
+// manifest is present with the expected size and checksum
+func verifyArchive(archivePath, format string, manifest archiveManifest) error {
+	expected := make(map[string]archiveManifestEntry, len(manifest.Files))
+	for _, entry := range manifest.Files {
+		expected[entry.Name] = entry
+	}
+
+	checkEntry := func(name string, modTime time.Time, content io.Reader) error {
+		entry, ok := expected[name]
+		if !ok {
+			return fmt.Errorf("unexpected archive entry %v", name)
+		}
+		hash := sha256.New()
+		size, err := io.Copy(hash, content)
+		if err != nil {
+			return err
+		}
+		if size != entry.Size || hex.EncodeToString(hash.Sum(nil)) != entry.SHA256 {
+			return fmt.Errorf("archive entry %v does not match the source file", name)
+		}
+		delete(expected, name)
+		return nil
+	}
+
+	var err error
+	switch format {
+	case "zip":
+		err = walkZipArchive(archivePath, checkEntry)
+	default:
+		err = walkTarGzArchive(archivePath, checkEntry)
+	}
+	if err != nil {
+		return err
+	}
+	if len(expected) > 0 {
+		return fmt.Errorf("%d files missing from archive", len(expected))
+	}
+	return nil
+}
+
+// archiveEntryFunc is called with the name, the modification time and the content of every regular file of an archive
+type archiveEntryFunc func(name string, modTime time.Time, content io.Reader) error
+
+func walkTarGzArchive(archivePath string, fn archiveEntryFunc) error {
+	archiveFile, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer func(archiveFile *os.File) {
+		err := archiveFile.Close()
+		if err != nil {
+			log.Warnln("Error closing archive", archivePath, err.Error())
+		}
+	}(archiveFile)
+
+	gzipReader, err := gzip.NewReader(archiveFile)
+	if err != nil {
+		return err
+	}
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(header.Name, header.ModTime, tarReader); err != nil {
+			return err
+		}
+	}
+}
+
+func walkZipArchive(archivePath string, fn archiveEntryFunc) error {
+	zipReader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer func(zipReader *zip.ReadCloser) {
+		err := zipReader.Close()
+		if err != nil {
+			log.Warnln("Error closing archive", archivePath, err.Error())
+		}
+	}(zipReader)
+
+	for _, zipFile := range zipReader.File {
+		if !zipFile.Mode().IsRegular() {
+			continue
+		}
+		content, err := zipFile.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(zipFile.Name, zipFile.Modified, content)
+		if closeErr := content.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeArchiveManifest(manifestPath string, manifest archiveManifest) error {
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(manifestPath, true, func(to io.Writer) error {
+		_, err := to.Write(content)
+		return err
+	}, nil)
+}
+
+// syncDir flushes the directory entries to disk, making the files created inside it durable
+func syncDir(dirName string) error {
+	dir, err := os.Open(dirName)
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	if closeErr := dir.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func checkArchiveParameters(archiveDir, format string) error {
+	if len(archiveDir) == 0 {
+		return nil
+	}
+	switch strings.ToLower(format) {
+	case "tar.gz", "zip":
+	default:
+		log.Errorln("Invalid archive format", format)
+		return errors.New("invalid archive format")
+	}
+
+	dirInfo, err := os.Stat(archiveDir)
+	if err != nil {
+		log.Errorln("Invalid archive directory", err)
+		return err
+	}
+	if !dirInfo.IsDir() {
+		log.Errorln("Archive directory must be a valid directory")
+		return errors.New("invalid archive directory")
+	}
+	return nil
+}
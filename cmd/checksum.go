@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/cespare/xxhash/v2"
+	log "github.com/sirupsen/logrus"
+	"hash"
+	"os"
+	"path"
+	"strings"
+)
+
+// checksumOptions controls the verification of the copies before the source is deleted
+type checksumOptions struct {
+	// verify is the checksum algorithm (sha256, xxhash), empty to skip the verification
+	verify string
+	// checksumFile writes the checksum of the copy in a sidecar file, in sha256sum format
+	checksumFile bool
+}
+
+func (opts checksumOptions) algorithm() string {
+	if len(opts.verify) == 0 {
+		return "sha256"
+	}
+	return strings.ToLower(opts.verify)
+}
+
+func newChecksumHash(algorithm string) hash.Hash {
+	if strings.ToLower(algorithm) == "xxhash" {
+		return xxhash.New()
+	}
+	return sha256.New()
+}
+
+// copyFileChecked copies the file, verifying the copy, preserving the selected metadata
+// and writing the checksum file when requested
+func copyFileChecked(fromFile string, toFile string, opts checksumOptions, preserve preserveOptions, permissions permissionOptions, replace bool) error {
+	if len(opts.verify) == 0 && !opts.checksumFile && !preserve.any() && !permissions.any() {
+		_, err := copyFileAtomic(fromFile, toFile, replace, "", nil)
+		return err
+	}
+	fileInfo, err := os.Stat(fromFile)
+	if err != nil {
+		return err
+	}
+	algorithm := opts.algorithm()
+	sum, err := copyFileAtomic(fromFile, toFile, replace, algorithm, func(tempFile string, sum []byte) error {
+		if len(opts.verify) > 0 {
+			if err := verifyFileCopy(tempFile, fileInfo.Size(), algorithm, sum); err != nil {
+				return err
+			}
+			log.Infof("Verified %v checksum of file %v", algorithm, toFile)
+		}
+		if err := preserveMetadata(fromFile, tempFile, fileInfo, preserve); err != nil {
+			return err
+		}
+		return setCopyPermissions(tempFile, toFile, permissions)
+	})
+	if err != nil || !opts.checksumFile {
+		return err
+	}
+	return writeChecksumFile(toFile, algorithm, sum)
+}
+
+// writeChecksumFile writes the checksum next to the file, with the algorithm as extension
+func writeChecksumFile(filePath string, algorithm string, sum []byte) error {
+	checksumPath := filePath + "." + algorithm
+	content := fmt.Sprintf("%v  %v\n", hex.EncodeToString(sum), path.Base(filePath))
+	tempFile := tempCopyPath(checksumPath)
+	err := os.WriteFile(tempFile, []byte(content), 0644)
+	if err == nil {
+		err = os.Rename(tempFile, checksumPath)
+	}
+	if err != nil {
+		if err := os.Remove(tempFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Warnln("Error removing incomplete checksum file", tempFile, err.Error())
+		}
+		return err
+	}
+	log.Infof("Checksum of file %v written to %v", path.Base(filePath), checksumPath)
+	return nil
+}
+
+func checkChecksumOptions(opts checksumOptions) error {
+	switch strings.ToLower(opts.verify) {
+	case "", "sha256", "xxhash":
+		return nil
+	}
+	log.Errorln("Invalid checksum algorithm", opts.verify)
+	return errors.New("invalid checksum algorithm")
+}
@@ -2,24 +2,89 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
+	"github.com/dustin/go-humanize"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"io/fs"
 	"os"
 	"path"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 )
 
 func init() {
 	CleanOldCmd.PersistentFlags().StringSliceVarP(&cleanOldParams.dirNames, "directory", "d", []string{}, "List of directories to cleanup")
 	CleanOldCmd.PersistentFlags().IntVar(&cleanOldParams.maxAgeDays, "max-age", 0, "Maximum age of the file in days")
+	CleanOldCmd.PersistentFlags().StringVar(&cleanOldParams.timeSourceName, "time-source", "mtime", "Timestamp used for the file age (mtime, atime, ctime, birth, name)")
+	CleanOldCmd.PersistentFlags().StringVar(&cleanOldParams.timePattern, "time-pattern", "", "Pattern on file name whose first capture group contains the file date, for the name time source")
+	CleanOldCmd.PersistentFlags().StringVar(&cleanOldParams.timeLayout, "time-layout", "2006-01-02", "Layout of the date in the file name, in Go time format")
+	CleanOldCmd.PersistentFlags().StringVar(&cleanOldParams.maxTotalSize, "max-total-size", "", "Maximum total size of the files in each directory (e.g. 50GB), oldest files are deleted first")
+	CleanOldCmd.PersistentFlags().IntVar(&cleanOldParams.keepLast, "keep-last", 0, "Number of newest files always kept, regardless of the other rules")
+	CleanOldCmd.PersistentFlags().StringVar(&cleanOldParams.keepGroupPattern, "keep-group-pattern", "", "Pattern on file name whose first capture group identifies the series for --keep-last")
+	CleanOldCmd.PersistentFlags().IntVar(&cleanOldParams.gfsDays, "gfs-days", 0, "Keep all the files of the given number of days (grandfather-father-son policy)")
+	CleanOldCmd.PersistentFlags().IntVar(&cleanOldParams.gfsWeeks, "gfs-weeks", 0, "Keep one file per day for the given number of weeks (grandfather-father-son policy)")
+	CleanOldCmd.PersistentFlags().IntVar(&cleanOldParams.gfsMonths, "gfs-months", 0, "Keep one file per week for the given number of months (grandfather-father-son policy)")
+	CleanOldCmd.PersistentFlags().IntVar(&cleanOldParams.gfsYears, "gfs-years", 0, "Keep one file per month for the given number of years (grandfather-father-son policy)")
+	CleanOldCmd.PersistentFlags().StringVar(&cleanOldParams.archiveDir, "archive-dir", "", "Archive old files in the given directory before deleting them")
+	CleanOldCmd.PersistentFlags().StringVar(&cleanOldParams.archiveFormat, "archive-format", "tar.gz", "Archive format (tar.gz, zip)")
+	CleanOldCmd.PersistentFlags().StringVar(&cleanOldParams.trashDir, "trash-dir", "", "Move old files to the given trash directory instead of deleting them")
+	CleanOldCmd.PersistentFlags().IntVar(&cleanOldParams.trashRetentionDays, "trash-retention", 0, "Purge files trashed more than the given number of days ago (0 means never)")
+	CleanOldCmd.PersistentFlags().StringSliceVar(&cleanOldParams.include.prefixes, "include-prefix", []string{}, "List of file name prefixes to include")
+	CleanOldCmd.PersistentFlags().StringSliceVar(&cleanOldParams.include.suffixes, "include-suffix", []string{}, "List of file name suffixes to include")
+	CleanOldCmd.PersistentFlags().StringSliceVar(&cleanOldParams.include.patterns, "include-pattern", []string{}, "List of file name patterns to include")
+	CleanOldCmd.PersistentFlags().StringSliceVar(&cleanOldParams.include.globs, "include-glob", []string{}, "List of file name globs to include")
+	CleanOldCmd.PersistentFlags().StringSliceVar(&cleanOldParams.exclude.prefixes, "exclude-prefix", []string{}, "List of file name prefixes to exclude")
+	CleanOldCmd.PersistentFlags().StringSliceVar(&cleanOldParams.exclude.suffixes, "exclude-suffix", []string{}, "List of file name suffixes to exclude")
+	CleanOldCmd.PersistentFlags().StringSliceVar(&cleanOldParams.exclude.patterns, "exclude-pattern", []string{}, "List of file name patterns to exclude")
+	CleanOldCmd.PersistentFlags().StringSliceVar(&cleanOldParams.exclude.globs, "exclude-glob", []string{}, "List of file name globs to exclude")
+	CleanOldCmd.PersistentFlags().StringVarP(&cleanOldParams.output, "output", "o", "text", "Output format of the plan of actions (text, json, csv)")
+	CleanOldCmd.PersistentFlags().IntVar(&cleanOldParams.batchSize, "batch-size", defaultBatchSize, "Number of directory entries read at a time")
+	CleanOldCmd.PersistentFlags().IntVar(&cleanOldParams.progressEvery, "progress-every", 0, "Log the scan progress every given number of files (0 means never)")
+	CleanOldCmd.PersistentFlags().IntVar(&cleanOldParams.workers, "workers", 1, "Number of directories cleaned in parallel")
+	CleanOldCmd.PersistentFlags().IntVar(&cleanOldParams.deleteWorkers, "delete-workers", 1, "Number of files of each directory deleted in parallel")
+	CleanOldCmd.PersistentFlags().BoolVar(&cleanOldParams.continueOnError, "continue-on-error", false, "Continue the cleanup after an error, reporting all the errors at the end")
 	CleanOldCmd.PersistentFlags().BoolVar(&cleanOldParams.dryRun, "dry-run", false, "Only check for old files without deleting")
+	CleanOldCmd.PersistentFlags().BoolVarP(&cleanOldParams.recursive, "recursive", "r", false, "Cleanup subdirectories recursively")
+	CleanOldCmd.PersistentFlags().IntVar(&cleanOldParams.maxDepth, "max-depth", 0, "Maximum depth of subdirectories to cleanup (0 means unlimited)")
+	CleanOldCmd.PersistentFlags().StringSliceVar(&cleanOldParams.includeDirs, "include-dir", []string{}, "List of glob patterns of subdirectory names to include")
+	CleanOldCmd.PersistentFlags().StringSliceVar(&cleanOldParams.excludeDirs, "exclude-dir", []string{}, "List of glob patterns of subdirectory names to exclude")
+	CleanOldCmd.PersistentFlags().BoolVar(&cleanOldParams.followSymlinks, "follow-symlinks", false, "Follow symlinked directories")
+	CleanOldCmd.PersistentFlags().BoolVar(&cleanOldParams.pruneEmpty, "prune-empty", false, "Remove directories left empty by the cleanup")
+	CleanOldCmd.PersistentFlags().IntVar(&cleanOldParams.pruneMinAgeDays, "prune-min-age", 0, "Minimum age of the empty directories to remove in days")
+	CleanOldCmd.PersistentFlags().StringSliceVar(&cleanOldParams.pruneProtect, "prune-protect", []string{}, "List of directories never removed by pruning")
 }
 
 type CleanOldParamsType struct {
-	dirNames   []string
-	maxAgeDays int
-	dryRun     bool
+	dirNames           []string
+	maxAgeDays         int
+	timeSourceName     string
+	timePattern        string
+	timeLayout         string
+	timeSource         timeSource
+	maxTotalSize       string
+	maxTotalBytes      uint64
+	keepLast           int
+	keepGroupPattern   string
+	keepGroupRegexp    *regexp.Regexp
+	archiveDir         string
+	archiveFormat      string
+	trashDir           string
+	trashRetentionDays int
+	include            fileFilter
+	exclude            fileFilter
+	output             string
+	plan               *planWriter
+	workers            int
+	deleteWorkers      int
+	continueOnError    bool
+	dryRun             bool
+	pruneEmpty         bool
+	pruneMinAgeDays    int
+	pruneProtect       []string
+	walkOptions
+	gfsOptions
 }
 
 var cleanOldParams = CleanOldParamsType{}
@@ -39,74 +104,352 @@ func cleanOldFiles(params CleanOldParamsType) error {
 	if err := checkParameters(params); err != nil {
 		return err
 	}
+	params.timeSource, _ = newTimeSource(params.timeSourceName, params.timePattern, params.timeLayout)
+	params.include.compile()
+	params.exclude.compile()
+	params.walkOptions.continueOnError = params.continueOnError
+	if len(params.maxTotalSize) > 0 {
+		params.maxTotalBytes, _ = humanize.ParseBytes(params.maxTotalSize)
+	}
+	if len(params.keepGroupPattern) > 0 {
+		params.keepGroupRegexp, _ = regexp.Compile(params.keepGroupPattern)
+	}
 
-	maxAgeDays := params.maxAgeDays
-	dryRun := params.dryRun
+	plan, err := newPlanWriter(params.output, "cleanold", params.dryRun, os.Stdout)
+	if err != nil {
+		return err
+	}
+	params.plan = plan
 
+	dirGroup := newTaskGroup(params.workers, params.continueOnError)
 	for _, dirName := range params.dirNames {
-		if err := cleanupDirectory(dirName, maxAgeDays, dryRun); err != nil {
-			log.Errorln("Error cleaning directory", dirName, err.Error())
-			return err
+		dirName := dirName
+		started := dirGroup.run(func() error {
+			if err := cleanupDirectory(dirName, params); err != nil {
+				log.Errorln("Error cleaning directory", dirName, err.Error())
+				return err
+			}
+			log.Infoln("Directory", dirName, "cleaned")
+			return nil
+		})
+		if !started {
+			break
 		}
-		log.Infoln("Directory", dirName, "cleaned")
+	}
+	errs := dirGroup.wait()
+
+	if err := plan.close(); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		if !params.continueOnError {
+			return errs[0]
+		}
+		errs = flattenErrors(errs)
+		log.Errorf("Cleanup completed with %d errors:", len(errs))
+		for _, err := range errs {
+			log.Errorln(" -", err.Error())
+		}
+		return fmt.Errorf("cleanup completed with %d errors", len(errs))
+	}
+
+	if len(params.trashDir) > 0 && params.trashRetentionDays > 0 {
+		return purgeTrash(params.trashDir, params.trashRetentionDays, params.dryRun)
 	}
 	return nil
 }
 
-func cleanupDirectory(dirName string, maxAgeDays int, dryRun bool) error {
-	directory, err := os.Open(dirName)
+type cleanCandidate struct {
+	rootDir  string
+	dirName  string
+	fileInfo os.FileInfo
+	fileTime time.Time
+	reason   string
+}
+
+// newCleanCandidate returns a candidate with the file time given by the time source,
+// or false if the file is excluded by the filters or its time cannot be determined
+func newCleanCandidate(rootDir, dirName string, fileInfo os.FileInfo, params CleanOldParamsType) (cleanCandidate, bool) {
+	if reason, ok := isFileIncluded(fileInfo.Name(), params.include, params.exclude); !ok {
+		if params.dryRun {
+			log.Infoln("Excluding file", fileInfo.Name(), reason)
+		} else {
+			log.Debugln("Excluding file", fileInfo.Name(), reason)
+		}
+		return cleanCandidate{}, false
+	}
+	fileTime, err := params.timeSource.fileTime(path.Join(dirName, fileInfo.Name()), fileInfo)
 	if err != nil {
-		return err
+		log.Warnln("Skipping file", fileInfo.Name(), err.Error())
+		return cleanCandidate{}, false
 	}
-	defer func(directory *os.File) {
-		err := directory.Close()
-		if err != nil {
-			log.Warnln("Error closing directory", dirName, err.Error())
+	return cleanCandidate{rootDir: rootDir, dirName: dirName, fileInfo: fileInfo, fileTime: fileTime}, true
+}
+
+func cleanupDirectory(rootDir string, params CleanOldParamsType) error {
+	var pruner *dirPruner
+	if params.pruneEmpty {
+		pruner = newDirPruner(rootDir, time.Duration(params.pruneMinAgeDays)*24*time.Hour, params.pruneProtect, params.dryRun)
+	}
+
+	startDate := time.Now().AddDate(0, 0, -params.maxAgeDays)
+	if params.maxAgeDays > 0 {
+		log.Infof("Cleaning directory %v from files with %v before %v (%d days old)", rootDir, params.timeSource, startDate.Format("2006-01-02"), params.maxAgeDays)
+	}
+	if params.maxTotalBytes > 0 {
+		log.Infof("Cleaning directory %v to fit within %v", rootDir, humanize.Bytes(params.maxTotalBytes))
+	}
+	if params.gfsOptions.enabled() {
+		log.Infof("Cleaning directory %v keeping all files for %d days, daily for %d weeks, weekly for %d months, monthly for %d years", rootDir, params.gfsDays, params.gfsWeeks, params.gfsMonths, params.gfsYears)
+	}
+
+	// With only the age rule every file can be checked on its own, otherwise
+	// the whole directory content must be known before deleting anything
+	deleteGroup := newTaskGroup(params.deleteWorkers, params.continueOnError)
+	if !params.needsFileList() {
+		err := walkDirectory(rootDir, params.walkOptions, func(dirName string, fileInfo os.FileInfo) error {
+			pruner.trackDir(dirName)
+			candidate, ok := newCleanCandidate(rootDir, dirName, fileInfo, params)
+			if !ok {
+				return nil
+			}
+			started := deleteGroup.run(func() error {
+				removed, err := checkIfFileIsOld(candidate, startDate, params)
+				if removed {
+					pruner.markRemoved(path.Join(candidate.dirName, candidate.fileInfo.Name()))
+				}
+				return err
+			})
+			if !started {
+				return errCleanupAborted
+			}
+			return nil
+		})
+		if err := waitDeletions(deleteGroup, err, params.continueOnError); err != nil {
+			return err
 		}
-	}(directory)
+		return pruner.prune()
+	}
 
-	dirContent, err := directory.Readdir(-1)
-	if err != nil {
+	var candidates []cleanCandidate
+	err := walkDirectory(rootDir, params.walkOptions, func(dirName string, fileInfo os.FileInfo) error {
+		pruner.trackDir(dirName)
+		if candidate, ok := newCleanCandidate(rootDir, dirName, fileInfo, params); ok {
+			candidates = append(candidates, candidate)
+		}
+		return nil
+	})
+	// The files found in the readable directories are still cleaned when continuing after errors
+	if err != nil && !params.continueOnError {
 		return err
 	}
+	walkErr := err
 
-	startDate := time.Now().AddDate(0, 0, -maxAgeDays)
-	log.Infof("Cleaning directory %v from files created before %v (%d days old)", dirName, startDate.Format("2006-01-02"), maxAgeDays)
-	for _, fileInfo := range dirContent {
-		if err := checkIfFileIsOld(dirName, fileInfo, startDate, dryRun); err != nil {
-			return err
+	expired := selectExpiredFiles(rootDir, candidates, startDate, params)
+	if len(params.archiveDir) > 0 && len(expired) > 0 {
+		if params.dryRun {
+			log.Infof("Archiving %d files of directory %v into %v", len(expired), rootDir, params.archiveDir)
+		} else {
+			archivePath, err := archiveFiles(rootDir, expired, params.archiveDir, strings.ToLower(params.archiveFormat))
+			if err != nil {
+				return err
+			}
+			log.Infof("Archived %d files of directory %v into %v", len(expired), rootDir, archivePath)
 		}
 	}
-	return nil
-}
 
-func checkIfFileIsOld(dirName string, fileInfo os.FileInfo, startDate time.Time, dryRun bool) error {
-	fileName := fileInfo.Name()
-	fileModTime := fileInfo.ModTime()
-	if fileModTime.Before(startDate) {
-		if fileInfo.IsDir() {
-			log.Infoln("Skipping directory", fileName)
+	for _, candidate := range expired {
+		candidate := candidate
+		started := deleteGroup.run(func() error {
+			if err := deleteOldFile(candidate, params); err != nil {
+				return err
+			}
+			pruner.markRemoved(path.Join(candidate.dirName, candidate.fileInfo.Name()))
 			return nil
+		})
+		if !started {
+			break
 		}
-		if fileInfo.Mode() == fs.ModeSymlink {
-			log.Infoln("Skipping symlink", fileName)
-			return nil
+	}
+	if err := waitDeletions(deleteGroup, walkErr, params.continueOnError); err != nil {
+		return err
+	}
+	return pruner.prune()
+}
+
+// errCleanupAborted stops the walk of a directory after a failed deletion
+var errCleanupAborted = errors.New("cleanup aborted")
+
+// waitDeletions waits for the pending deletions, returning the walk error or
+// the first deletion error, or all of them when continuing after errors
+func waitDeletions(deleteGroup *taskGroup, walkErr error, continueOnError bool) error {
+	errs := deleteGroup.wait()
+	if len(errs) == 0 {
+		return walkErr
+	}
+	if errors.Is(walkErr, errCleanupAborted) {
+		walkErr = nil
+	}
+	if !continueOnError {
+		return errs[0]
+	}
+	if walkErr != nil {
+		errs = append(errs, walkErr)
+	}
+	return multiError(errs)
+}
+
+func (params CleanOldParamsType) needsFileList() bool {
+	return params.maxTotalBytes > 0 || params.keepLast > 0 || params.gfsOptions.enabled() || len(params.archiveDir) > 0
+}
+
+// selectExpiredFiles returns the files older than the max age or not retained by
+// the grandfather-father-son policy, followed by the oldest of the remaining
+// files needed to fit the directory in the size limit.
+// The newest files of each series protected by keep-last are never returned.
+func selectExpiredFiles(dirName string, candidates []cleanCandidate, startDate time.Time, params CleanOldParamsType) []cleanCandidate {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].fileTime.Before(candidates[j].fileTime)
+	})
+	kept := selectKeptFiles(candidates, params)
+	gfsExpired := selectGFSExpiredFiles(candidates, params.gfsOptions)
+
+	var expired, remaining []cleanCandidate
+	var totalSize uint64
+	for i, candidate := range candidates {
+		if kept[i] {
+			totalSize += uint64(candidate.fileInfo.Size())
+			continue
+		}
+		if gfsExpired[i] {
+			candidate.reason = "gfs"
+			expired = append(expired, candidate)
+			continue
+		}
+		if params.maxAgeDays > 0 && candidate.fileTime.Before(startDate) {
+			candidate.reason = "max-age"
+			expired = append(expired, candidate)
+			continue
 		}
+		remaining = append(remaining, candidate)
+		totalSize += uint64(candidate.fileInfo.Size())
+	}
 
-		fileAge := time.Now().Sub(fileModTime).Hours() / 24
-		if dryRun {
-			fn := fileName
-			if len(fileName) > 30 {
-				fn = fileName[:27] + "..."
+	if params.maxTotalBytes > 0 && totalSize > params.maxTotalBytes {
+		log.Infof("Directory %v exceeds size limit: %v of %v", dirName, humanize.Bytes(totalSize), humanize.Bytes(params.maxTotalBytes))
+		for _, candidate := range remaining {
+			if totalSize <= params.maxTotalBytes {
+				break
 			}
-			log.Infof("Candidate file %-30v\t%10d bytes\t%v\t%.0f days old", fn, fileInfo.Size(), fileModTime.Format(time.RFC3339), fileAge)
-		} else {
-			log.Infof("Deleting %-30v\t%10d bytes\t%v\t%.0f days old", fileName, fileInfo.Size(), fileModTime.Format(time.RFC3339), fileAge)
-			if err := os.Remove(path.Join(dirName, fileName)); err != nil {
-				log.Errorln("Impossible to delete file", fileName)
-				return err
+			candidate.reason = "max-total-size"
+			expired = append(expired, candidate)
+			totalSize -= uint64(candidate.fileInfo.Size())
+		}
+	}
+	return expired
+}
+
+// selectKeptFiles marks the newest keep-last files of each series, given the
+// candidates sorted from the oldest to the newest. Series are counted separately
+// in each directory, and the files not matching the group pattern form a series
+// of their own, distinct from the matching files with an empty capture.
+func selectKeptFiles(candidates []cleanCandidate, params CleanOldParamsType) []bool {
+	kept := make([]bool, len(candidates))
+	if params.keepLast <= 0 {
+		return kept
+	}
+
+	type series struct {
+		dirName string
+		group   string
+		matched bool
+	}
+	seriesCount := map[series]int{}
+	for i := len(candidates) - 1; i >= 0; i-- {
+		fileName := candidates[i].fileInfo.Name()
+		key := series{dirName: candidates[i].dirName}
+		if params.keepGroupRegexp != nil {
+			if match := params.keepGroupRegexp.FindStringSubmatch(fileName); match != nil {
+				key.matched = true
+				key.group = match[0]
+				if len(match) > 1 {
+					key.group = match[1]
+				}
 			}
 		}
+		if seriesCount[key] < params.keepLast {
+			seriesCount[key]++
+			kept[i] = true
+			log.Debugf("Keeping file %v, one of the last %d of series %q in directory %v", fileName, params.keepLast, key.group, key.dirName)
+		}
+	}
+	return kept
+}
+
+func checkIfFileIsOld(candidate cleanCandidate, startDate time.Time, params CleanOldParamsType) (bool, error) {
+	if candidate.fileTime.Before(startDate) {
+		candidate.reason = "max-age"
+		if err := deleteOldFile(candidate, params); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func deleteOldFile(candidate cleanCandidate, params CleanOldParamsType) error {
+	fileInfo := candidate.fileInfo
+	fileName := fileInfo.Name()
+	fileModTime := candidate.fileTime
+	fileAge := time.Now().Sub(fileModTime).Hours() / 24
+	filePath := path.Join(candidate.dirName, fileName)
+
+	entry := planEntry{
+		Path:    filePath,
+		Root:    candidate.rootDir,
+		Size:    fileInfo.Size(),
+		ModTime: fileInfo.ModTime(),
+		AgeDays: fileAge,
+		Action:  "DELETE",
+		Rule:    "cleanold " + candidate.reason,
+	}
+	if len(params.archiveDir) > 0 {
+		entry.Action = "ARCHIVE"
+		entry.Destination = params.archiveDir
+		entry.Options = map[string]string{"format": strings.ToLower(params.archiveFormat)}
+		if len(params.trashDir) > 0 {
+			entry.Options["trashDir"] = absPath(params.trashDir)
+		}
+	} else if len(params.trashDir) > 0 {
+		entry.Action = "TRASH"
+		entry.Destination = params.trashDir
+	}
+	if err := params.plan.add(entry); err != nil {
+		return err
+	}
+
+	if params.dryRun {
+		fn := fileName
+		if len(fileName) > 30 {
+			fn = fileName[:27] + "..."
+		}
+		log.Infof("Candidate file %-30v\t%10d bytes\t%v\t%.0f days old", fn, fileInfo.Size(), fileModTime.Format(time.RFC3339), fileAge)
+		return nil
+	}
+
+	if len(params.trashDir) > 0 {
+		log.Infof("Trashing %-30v\t%10d bytes\t%v\t%.0f days old", fileName, fileInfo.Size(), fileModTime.Format(time.RFC3339), fileAge)
+		if err := trashFile(params.trashDir, filePath, "cleanold "+candidate.reason); err != nil {
+			log.Errorln("Impossible to trash file", fileName)
+			return err
+		}
+		return nil
+	}
+
+	log.Infof("Deleting %-30v\t%10d bytes\t%v\t%.0f days old", fileName, fileInfo.Size(), fileModTime.Format(time.RFC3339), fileAge)
+	if err := os.Remove(filePath); err != nil {
+		log.Errorln("Impossible to delete file", fileName)
+		return err
 	}
 	return nil
 }
@@ -136,10 +479,78 @@ func checkParameters(params CleanOldParamsType) error {
 		}
 
 	}
-	if params.maxAgeDays <= 0 {
+	if params.maxAgeDays < 0 || (params.maxAgeDays == 0 && len(params.maxTotalSize) == 0 && !params.gfsOptions.enabled()) {
 		log.Errorln("Invalid maxAgeDays, positive number expected")
 		return errors.New("invalid maxAgeDays")
 	}
 
+	if params.keepLast < 0 {
+		log.Errorln("Invalid keep last, zero or positive number expected")
+		return errors.New("invalid keep-last")
+	}
+
+	if len(params.keepGroupPattern) > 0 {
+		if params.keepLast == 0 {
+			log.Errorln("Keep group pattern requires keep last")
+			return errors.New("missing keep-last")
+		}
+		if _, err := regexp.Compile(params.keepGroupPattern); err != nil {
+			log.Errorln("Invalid keep group pattern regexp", params.keepGroupPattern, err.Error())
+			return err
+		}
+	}
+
+	if len(params.maxTotalSize) > 0 {
+		maxTotalBytes, err := humanize.ParseBytes(params.maxTotalSize)
+		if err != nil || maxTotalBytes == 0 {
+			log.Errorln("Invalid max total size", params.maxTotalSize)
+			return errors.New("invalid max-total-size")
+		}
+	}
+
+	if _, err := newTimeSource(params.timeSourceName, params.timePattern, params.timeLayout); err != nil {
+		return err
+	}
+
+	if err := checkFileFilter(params.include); err != nil {
+		return err
+	}
+	if err := checkFileFilter(params.exclude); err != nil {
+		return err
+	}
+
+	if err := checkGFSOptions(params.gfsOptions, params.maxAgeDays); err != nil {
+		return err
+	}
+
+	if err := checkArchiveParameters(params.archiveDir, params.archiveFormat); err != nil {
+		return err
+	}
+
+	if err := checkTrashDir(params.trashDir, false); err != nil {
+		return err
+	}
+
+	if err := checkOutputFormat(params.output); err != nil {
+		return err
+	}
+
+	if params.workers < 1 || params.deleteWorkers < 1 {
+		log.Errorln("Invalid number of workers, positive number expected")
+		return errors.New("invalid workers")
+	}
+	if params.trashRetentionDays < 0 {
+		log.Errorln("Invalid trash retention, zero or positive number expected")
+		return errors.New("invalid trash-retention")
+	}
+
+	if err := checkWalkOptions(params.walkOptions); err != nil {
+		return err
+	}
+
+	if err := checkPruneParameters(params.pruneMinAgeDays, params.pruneProtect); err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"os"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// testFileInfo describes a file by its name only
+type testFileInfo struct {
+	name string
+}
+
+func (fi testFileInfo) Name() string       { return fi.name }
+func (fi testFileInfo) Size() int64        { return 0 }
+func (fi testFileInfo) Mode() os.FileMode  { return 0644 }
+func (fi testFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi testFileInfo) IsDir() bool        { return false }
+func (fi testFileInfo) Sys() any           { return nil }
+
+func TestSelectKeptFiles(t *testing.T) {
+	// files are given from the oldest to the newest, as dir/name
+	tests := []struct {
+		name     string
+		keepLast int
+		pattern  string
+		files    [][2]string
+		want     []bool
+	}{
+		{
+			name:     "disabled",
+			keepLast: 0,
+			files:    [][2]string{{"/b", "a.log"}, {"/b", "b.log"}},
+			want:     []bool{false, false},
+		},
+		{
+			name:     "newest files",
+			keepLast: 2,
+			files:    [][2]string{{"/b", "a.log"}, {"/b", "b.log"}, {"/b", "c.log"}},
+			want:     []bool{false, true, true},
+		},
+		{
+			name:     "each directory",
+			keepLast: 1,
+			files:    [][2]string{{"/b/db1", "a.sql"}, {"/b/db1", "b.sql"}, {"/b/db2", "c.sql"}, {"/b/db2", "d.sql"}, {"/b/db2", "e.sql"}},
+			want:     []bool{false, true, false, false, true},
+		},
+		{
+			name:     "stopped series in another directory",
+			keepLast: 2,
+			files:    [][2]string{{"/b/old", "a.sql"}, {"/b/new", "b.sql"}, {"/b/new", "c.sql"}, {"/b/new", "d.sql"}},
+			want:     []bool{true, false, true, true},
+		},
+		{
+			name:     "series of the group pattern",
+			keepLast: 1,
+			pattern:  `^(\w+)-\d+\.sql$`,
+			files:    [][2]string{{"/b", "db1-1.sql"}, {"/b", "db2-1.sql"}, {"/b", "db1-2.sql"}, {"/b", "db2-2.sql"}},
+			want:     []bool{false, false, true, true},
+		},
+		{
+			name:     "same group in different directories",
+			keepLast: 1,
+			pattern:  `^(\w+)-\d+\.sql$`,
+			files:    [][2]string{{"/b/x", "db-1.sql"}, {"/b/y", "db-1.sql"}, {"/b/x", "db-2.sql"}},
+			want:     []bool{false, true, true},
+		},
+		{
+			name:     "files not matching apart from empty captures",
+			keepLast: 1,
+			pattern:  `^(\w*)-\d+\.sql$`,
+			files:    [][2]string{{"/b", "-1.sql"}, {"/b", "notes.txt"}, {"/b", "-2.sql"}, {"/b", "readme.txt"}},
+			want:     []bool{false, false, true, true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			params := CleanOldParamsType{keepLast: test.keepLast}
+			if len(test.pattern) > 0 {
+				params.keepGroupRegexp = regexp.MustCompile(test.pattern)
+			}
+			candidates := make([]cleanCandidate, len(test.files))
+			for i, file := range test.files {
+				candidates[i] = cleanCandidate{dirName: file[0], fileInfo: testFileInfo{name: file[1]}}
+			}
+			if got := selectKeptFiles(candidates, params); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("selectKeptFiles() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
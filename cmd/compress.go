@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"github.com/klauspost/compress/zstd"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// defaultCompression is the format of the COMPRESS action when not configured
+const defaultCompression = "gzip"
+
+// defaultMaxExtractSize limits the total size of the files extracted from each archive, so that
+// a decompression bomb cannot fill the file system
+const defaultMaxExtractSize = "10GB"
+
+// compressedExt returns the extension added to the files compressed in the given format
+func compressedExt(format string) string {
+	if strings.ToLower(format) == "zstd" {
+		return ".zst"
+	}
+	return ".gz"
+}
+
+// compressFile writes the compressed copy of the file, atomically replacing toFile when replace is set
+func compressFile(fromFile string, toFile string, format string, preserve preserveOptions, permissions permissionOptions, replace bool) error {
+	fileInfo, err := os.Stat(fromFile)
+	if err != nil {
+		return err
+	}
+	from, err := os.Open(fromFile)
+	if err != nil {
+		return err
+	}
+	defer func(from *os.File) {
+		err := from.Close()
+		if err != nil {
+			log.Warnln("Error closing source file", fromFile, err.Error())
+		}
+	}(from)
+
+	return writeFileAtomic(toFile, replace, func(to io.Writer) error {
+		var writer io.WriteCloser
+		if strings.ToLower(format) == "zstd" {
+			if writer, err = zstd.NewWriter(to); err != nil {
+				return err
+			}
+		} else {
+			gzipWriter := gzip.NewWriter(to)
+			gzipWriter.Name = fileInfo.Name()
+			gzipWriter.ModTime = fileInfo.ModTime()
+			writer = gzipWriter
+		}
+		_, err := io.Copy(writer, from)
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}, func(tempFile string) error {
+		if err := preserveMetadata(fromFile, tempFile, fileInfo, preserve); err != nil {
+			return err
+		}
+		return setCopyPermissions(tempFile, toFile, permissions)
+	})
+}
+
+// extractArchive unpacks a zip or tar.gz archive in destDir, applying the conflict policy to
+// every extracted file. Entries that would be written outside destDir are rejected, and the
+// extraction fails when the files extracted exceed maxBytes in total.
+func extractArchive(archivePath string, destDir string, conflict string, maxBytes uint64) error {
+	walkArchive, err := archiveWalker(archivePath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return err
+	}
+
+	var extracted uint64
+	return walkArchive(archivePath, func(name string, modTime time.Time, content io.Reader) error {
+		entryName, err := safeEntryName(name)
+		if err != nil {
+			return err
+		}
+		entryPath := path.Join(destDir, entryName)
+		if err := createDestDir(destDir, entryPath); err != nil {
+			return err
+		}
+		resolvedPath, ok, err := resolveConflictTime(conflict, entryName, modTime, entryPath)
+		if err != nil || !ok {
+			return err
+		}
+		log.Debugf("Extracting %v to %v", name, resolvedPath)
+		tempFile, err := writeTempFile(resolvedPath, func(to io.Writer) error {
+			// Reading one byte more than the remaining size detects the archives exceeding it
+			written, err := io.Copy(to, io.LimitReader(content, int64(maxBytes-extracted)+1))
+			extracted += uint64(written)
+			if err == nil && extracted > maxBytes {
+				err = fmt.Errorf("files extracted from archive %v exceed %v", path.Base(archivePath), humanize.Bytes(maxBytes))
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if !modTime.IsZero() {
+			if err := os.Chtimes(tempFile, modTime, modTime); err != nil {
+				removeTempFile(tempFile)
+				return err
+			}
+		}
+		// The conflict policy is applied again when the file is created while extracting it
+		for attempt := 1; ; attempt++ {
+			err := placeFile(tempFile, resolvedPath, replacesDestination(conflict))
+			if !errors.Is(err, errDestinationExists) || attempt == maxPlaceAttempts {
+				if err != nil {
+					removeTempFile(tempFile)
+				}
+				return err
+			}
+			if resolvedPath, ok, err = resolveConflictTime(conflict, entryName, modTime, entryPath); err != nil || !ok {
+				removeTempFile(tempFile)
+				return err
+			}
+		}
+	})
+}
+
+// archiveWalker returns the function reading the entries of the archive, based on its extension
+func archiveWalker(archivePath string) (func(string, archiveEntryFunc) error, error) {
+	name := strings.ToLower(archivePath)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return walkZipArchive, nil
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return walkTarGzArchive, nil
+	}
+	return nil, fmt.Errorf("unsupported archive %v, zip or tar.gz expected", path.Base(archivePath))
+}
+
+// safeEntryName cleans the name of an archive entry, rejecting absolute names and names
+// escaping the destination directory
+func safeEntryName(name string) (string, error) {
+	entryName := path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	if path.IsAbs(entryName) || entryName == ".." || strings.HasPrefix(entryName, "../") {
+		return "", fmt.Errorf("invalid archive entry %v outside the destination directory", name)
+	}
+	if entryName == "." {
+		return "", errors.New("invalid empty archive entry")
+	}
+	return entryName, nil
+}
+
+func checkCompression(format string) error {
+	switch strings.ToLower(format) {
+	case "", "gzip", "zstd":
+		return nil
+	}
+	log.Errorln("Invalid compression format", format)
+	return errors.New("invalid compression format")
+}
+
+// parseMaxExtractSize returns the maximum total size in bytes of the files extracted from an archive,
+// the default one when not configured
+func parseMaxExtractSize(size string) (uint64, error) {
+	if len(size) == 0 {
+		size = defaultMaxExtractSize
+	}
+	maxBytes, err := humanize.ParseBytes(size)
+	if err != nil || maxBytes == 0 || maxBytes > math.MaxInt64 {
+		log.Errorln("Invalid max extract size", size)
+		return 0, errors.New("invalid max-extract-size")
+	}
+	return maxBytes, nil
+}
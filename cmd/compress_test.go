@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSafeEntryName(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   string
+		want    string
+		wantErr bool
+	}{
+		{name: "file", entry: "report.csv", want: "report.csv"},
+		{name: "nested file", entry: "2024/03/report.csv", want: "2024/03/report.csv"},
+		{name: "cleaned path", entry: "./a//b/../report.csv", want: "a/report.csv"},
+		{name: "backslashes", entry: `a\b\report.csv`, want: "a/b/report.csv"},
+		{name: "dot dot inside the directory", entry: "a/../report.csv", want: "report.csv"},
+		{name: "parent directory", entry: "..", wantErr: true},
+		{name: "escaping the directory", entry: "../report.csv", wantErr: true},
+		{name: "escaping after cleaning", entry: "a/../../report.csv", wantErr: true},
+		{name: "escaping with backslashes", entry: `..\report.csv`, wantErr: true},
+		{name: "absolute path", entry: "/etc/passwd", wantErr: true},
+		{name: "absolute path with backslashes", entry: `\etc\passwd`, wantErr: true},
+		{name: "empty", entry: "", wantErr: true},
+		{name: "current directory", entry: "./", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := safeEntryName(test.entry)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("safeEntryName(%q) = %q, want error", test.entry, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("safeEntryName(%q) error: %v", test.entry, err)
+			}
+			if got != test.want {
+				t.Errorf("safeEntryName(%q) = %q, want %q", test.entry, got, test.want)
+			}
+		})
+	}
+}
+
+// testArchiveEntry is a file of a test archive
+type testArchiveEntry struct {
+	name    string
+	content string
+}
+
+// writeTestArchive writes the entries in a tar.gz or zip archive, by extension
+func writeTestArchive(t *testing.T, archivePath string, entries []testArchiveEntry, modTime time.Time) {
+	t.Helper()
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(file *os.File) {
+		if err := file.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}(file)
+
+	var add func(entry testArchiveEntry) (io.Writer, error)
+	var closeArchive func() error
+	if strings.HasSuffix(archivePath, ".zip") {
+		zipWriter := zip.NewWriter(file)
+		add = func(entry testArchiveEntry) (io.Writer, error) {
+			return zipWriter.CreateHeader(&zip.FileHeader{Name: entry.name, Method: zip.Deflate, Modified: modTime})
+		}
+		closeArchive = zipWriter.Close
+	} else {
+		gzipWriter := gzip.NewWriter(file)
+		tarWriter := tar.NewWriter(gzipWriter)
+		add = func(entry testArchiveEntry) (io.Writer, error) {
+			header := &tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.content)), ModTime: modTime, Typeflag: tar.TypeReg}
+			return tarWriter, tarWriter.WriteHeader(header)
+		}
+		closeArchive = func() error {
+			if err := tarWriter.Close(); err != nil {
+				return err
+			}
+			return gzipWriter.Close()
+		}
+	}
+	for _, entry := range entries {
+		to, err := add(entry)
+		if err == nil {
+			_, err = io.WriteString(to, entry.content)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := closeArchive(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractArchive(t *testing.T) {
+	modTime := time.Date(2024, time.March, 5, 14, 30, 0, 0, time.Local)
+	entries := []testArchiveEntry{{"a.txt", "0123456789"}, {"sub/b.txt", "01234567890123456789"}}
+	tests := []struct {
+		name     string
+		archive  string
+		entries  []testArchiveEntry
+		maxBytes uint64
+		// want are the files expected in the destination directory
+		want    []string
+		wantErr bool
+	}{
+		{name: "tar.gz", archive: "files.tar.gz", entries: entries, maxBytes: 1000, want: []string{"a.txt", "sub/b.txt"}},
+		{name: "zip", archive: "files.zip", entries: entries, maxBytes: 1000, want: []string{"a.txt", "sub/b.txt"}},
+		{name: "tar.gz exactly at the size limit", archive: "files.tar.gz", entries: entries, maxBytes: 30, want: []string{"a.txt", "sub/b.txt"}},
+		{name: "zip exactly at the size limit", archive: "files.zip", entries: entries, maxBytes: 30, want: []string{"a.txt", "sub/b.txt"}},
+		{name: "tar.gz over the size limit", archive: "files.tar.gz", entries: entries, maxBytes: 29, want: []string{"a.txt"}, wantErr: true},
+		{name: "zip over the size limit", archive: "files.zip", entries: entries, maxBytes: 29, want: []string{"a.txt"}, wantErr: true},
+		{name: "first entry over the size limit", archive: "files.zip", entries: entries, maxBytes: 5, wantErr: true},
+		{name: "entry outside the directory", archive: "files.tar.gz", entries: []testArchiveEntry{{"../escape.txt", "x"}}, maxBytes: 1000, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archivePath, destDir := path.Join(dir, test.archive), path.Join(dir, "dest")
+			writeTestArchive(t, archivePath, test.entries, modTime)
+
+			err := extractArchive(archivePath, destDir, "fail", test.maxBytes)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("extractArchive() error = %v, want error %v", err, test.wantErr)
+			}
+			var found []string
+			err = walkDirectory(dir, walkOptions{recursive: true}, func(dirName string, fileInfo os.FileInfo) error {
+				if fileInfo.Name() == test.archive {
+					return nil
+				}
+				filePath := path.Join(dirName, fileInfo.Name())
+				if isTempCopy(fileInfo.Name()) {
+					t.Errorf("temporary file %v left", filePath)
+				}
+				// The files keep the modification time of the archive entries
+				if !fileInfo.ModTime().Equal(modTime) {
+					t.Errorf("file %v modified %v, want %v", filePath, fileInfo.ModTime(), modTime)
+				}
+				found = append(found, strings.TrimPrefix(filePath, destDir+"/"))
+				return nil
+			})
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				t.Fatal(err)
+			}
+			sort.Strings(found)
+			if strings.Join(found, ",") != strings.Join(test.want, ",") {
+				t.Errorf("extracted files %v, want %v", found, test.want)
+			}
+		})
+	}
+}
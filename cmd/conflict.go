@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaultConflictPolicy keeps the original behavior, replacing the existing destination files
+const defaultConflictPolicy = "overwrite"
+
+// defaultRenameConflictPolicy is the policy of the RENAME action when not configured, so that
+// renaming a file never replaces another file in the same directory
+const defaultRenameConflictPolicy = "fail"
+
+// resolveConflict applies the conflict policy when the destination file already exists,
+// returning the path to write and false when the file must not be written at all
+func resolveConflict(policy, sourcePath, destPath string) (string, bool, error) {
+	var sourceTime time.Time
+	if strings.ToLower(policy) == "keep-newer" {
+		sourceInfo, err := os.Stat(sourcePath)
+		if err != nil {
+			return "", false, err
+		}
+		sourceTime = sourceInfo.ModTime()
+	}
+	return resolveConflictTime(policy, path.Base(sourcePath), sourceTime, destPath)
+}
+
+// resolveConflictTime is resolveConflict for a source file with the given name and modification
+// time, compared with the existing destination file by the keep-newer policy
+func resolveConflictTime(policy, sourceName string, sourceTime time.Time, destPath string) (string, bool, error) {
+	destInfo, err := os.Lstat(destPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return destPath, true, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	switch strings.ToLower(policy) {
+	case "fail":
+		return "", false, fmt.Errorf("destination file %v already exists", destPath)
+	case "skip":
+		log.Infof("Skipping file %v, destination file %v already exists", sourceName, destPath)
+		return "", false, nil
+	case "rename":
+		newPath, err := uniqueDestPath(destPath, "")
+		if err != nil {
+			return "", false, err
+		}
+		log.Infof("Destination file %v already exists, renaming to %v", destPath, path.Base(newPath))
+		return newPath, true, nil
+	case "rename-timestamp":
+		newPath, err := uniqueDestPath(destPath, time.Now().Format("20060102-150405"))
+		if err != nil {
+			return "", false, err
+		}
+		log.Infof("Destination file %v already exists, renaming to %v", destPath, path.Base(newPath))
+		return newPath, true, nil
+	case "keep-newer":
+		if !sourceTime.After(destInfo.ModTime()) {
+			log.Infof("Skipping file %v, destination file %v is newer", sourceName, destPath)
+			return "", false, nil
+		}
+		log.Infof("Overwriting destination file %v with newer file %v", destPath, sourceName)
+		return destPath, true, nil
+	}
+	log.Infof("Overwriting destination file %v", destPath)
+	return destPath, true, nil
+}
+
+// replacesDestination reports whether the conflict policy allows to replace an existing destination file
+func replacesDestination(policy string) bool {
+	switch strings.ToLower(policy) {
+	case "fail", "skip", "rename", "rename-timestamp":
+		return false
+	}
+	return true
+}
+
+// errDestinationExists is returned when the destination file is created by someone else after
+// the conflict policy was applied, and the policy does not allow to replace it
+var errDestinationExists = errors.New("destination file created while processing")
+
+// maxPlaceAttempts is the number of times the conflict policy is applied to a file whose
+// destination keeps being created by someone else, before giving up
+const maxPlaceAttempts = 3
+
+// placeFile renames the file to its final path. Unless replace is set an existing destination
+// file is never replaced, even when it was created after the conflict policy was applied.
+func placeFile(fromFile string, toFile string, replace bool) error {
+	if replace {
+		return os.Rename(fromFile, toFile)
+	}
+	err := renameNoReplace(fromFile, toFile)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%w: %v", errDestinationExists, toFile)
+	}
+	return err
+}
+
+// linkNoReplace renames the file creating a hard link with the new name, which fails when
+// the destination exists, and then removing the old name. On file systems without hard links
+// the destination is checked before renaming the file, which is not atomic.
+func linkNoReplace(fromFile string, toFile string) error {
+	err := os.Link(fromFile, toFile)
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, errors.ErrUnsupported) {
+		log.Warnf("Hard links not supported, checking that %v does not exist before renaming, not atomically", toFile)
+		if _, err := os.Lstat(toFile); err == nil {
+			return &os.LinkError{Op: "rename", Old: fromFile, New: toFile, Err: os.ErrExist}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return os.Rename(fromFile, toFile)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(fromFile)
+}
+
+// uniqueDestPath adds the suffix, and then a counter, to the file name until the path is not used
+func uniqueDestPath(destPath, suffix string) (string, error) {
+	ext := path.Ext(destPath)
+	base := strings.TrimSuffix(destPath, ext)
+	for i := 0; ; i++ {
+		var newPath string
+		switch {
+		case len(suffix) > 0 && i == 0:
+			newPath = fmt.Sprintf("%v-%v%v", base, suffix, ext)
+		case len(suffix) > 0:
+			newPath = fmt.Sprintf("%v-%v-%d%v", base, suffix, i, ext)
+		case i == 0:
+			continue
+		default:
+			newPath = fmt.Sprintf("%v-%d%v", base, i, ext)
+		}
+		_, err := os.Lstat(newPath)
+		if errors.Is(err, os.ErrNotExist) {
+			return newPath, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+func checkConflictPolicy(policy string) error {
+	switch strings.ToLower(policy) {
+	case "", "fail", "skip", "overwrite", "rename", "rename-timestamp", "keep-newer":
+		return nil
+	}
+	log.Errorln("Invalid conflict policy", policy)
+	return errors.New("invalid conflict policy")
+}
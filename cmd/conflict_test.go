@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestUniqueDestPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string
+		// links are dangling symbolic links, which take their name as well
+		links    []string
+		destName string
+		suffix   string
+		want     string
+	}{
+		{name: "first number", existing: []string{"report.csv"}, destName: "report.csv", want: "report-1.csv"},
+		{name: "next free number", existing: []string{"report.csv", "report-1.csv", "report-2.csv"}, destName: "report.csv", want: "report-3.csv"},
+		{name: "without extension", existing: []string{"report"}, destName: "report", want: "report-1"},
+		{name: "last extension only", existing: []string{"report.tar.gz"}, destName: "report.tar.gz", want: "report.tar-1.gz"},
+		{name: "suffix", existing: []string{"report.csv"}, destName: "report.csv", suffix: "20240305", want: "report-20240305.csv"},
+		{name: "suffix taken", existing: []string{"report.csv", "report-20240305.csv"}, destName: "report.csv", suffix: "20240305", want: "report-20240305-1.csv"},
+		{name: "suffix and number taken", existing: []string{"report.csv", "report-20240305.csv", "report-20240305-1.csv"}, destName: "report.csv", suffix: "20240305", want: "report-20240305-2.csv"},
+		{name: "dangling link", existing: []string{"report.csv"}, links: []string{"report-1.csv"}, destName: "report.csv", want: "report-2.csv"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range test.existing {
+				if err := os.WriteFile(path.Join(dir, name), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, name := range test.links {
+				if err := os.Symlink(path.Join(dir, "missing"), path.Join(dir, name)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := uniqueDestPath(path.Join(dir, test.destName), test.suffix)
+			if err != nil {
+				t.Fatalf("uniqueDestPath() error: %v", err)
+			}
+			if want := path.Join(dir, test.want); got != want {
+				t.Errorf("uniqueDestPath() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestResolveConflict(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		policy     string
+		destExists bool
+		// destAge is how much older than the source the destination is, negative when newer
+		destAge time.Duration
+		want    string
+		wantOk  bool
+		wantErr bool
+	}{
+		{name: "no conflict", policy: "fail", want: "report.csv", wantOk: true},
+		{name: "fail", policy: "fail", destExists: true, wantErr: true},
+		{name: "skip", policy: "skip", destExists: true},
+		{name: "overwrite", policy: "overwrite", destExists: true, want: "report.csv", wantOk: true},
+		{name: "default overwrite", policy: "", destExists: true, want: "report.csv", wantOk: true},
+		{name: "rename", policy: "rename", destExists: true, want: "report-1.csv", wantOk: true},
+		{name: "policy case", policy: "RENAME", destExists: true, want: "report-1.csv", wantOk: true},
+		{name: "keep newer source", policy: "keep-newer", destExists: true, destAge: time.Hour, want: "report.csv", wantOk: true},
+		{name: "keep newer destination", policy: "keep-newer", destExists: true, destAge: -time.Hour},
+		{name: "keep newer same time", policy: "keep-newer", destExists: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sourceDir, destDir := t.TempDir(), t.TempDir()
+			sourcePath, destPath := path.Join(sourceDir, "report.csv"), path.Join(destDir, "report.csv")
+			writeTestFile(t, sourcePath, "source", now)
+			if test.destExists {
+				writeTestFile(t, destPath, "dest", now.Add(-test.destAge))
+			}
+			got, ok, err := resolveConflict(test.policy, sourcePath, destPath)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("resolveConflict() = %q, %v, want error", got, ok)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveConflict() error: %v", err)
+			}
+			if ok != test.wantOk {
+				t.Fatalf("resolveConflict() ok = %v, want %v", ok, test.wantOk)
+			}
+			if ok && got != path.Join(destDir, test.want) {
+				t.Errorf("resolveConflict() = %q, want %q", got, path.Join(destDir, test.want))
+			}
+		})
+	}
+}
+
+func TestResolveConflictTimestamp(t *testing.T) {
+	dir := t.TempDir()
+	destPath := path.Join(dir, "report.csv")
+	writeTestFile(t, destPath, "dest", time.Now())
+	got, ok, err := resolveConflictTime("rename-timestamp", "report.csv", time.Now(), destPath)
+	if err != nil || !ok {
+		t.Fatalf("resolveConflictTime() = %q, %v, %v", got, ok, err)
+	}
+	if !regexp.MustCompile(`/report-\d{8}-\d{6}\.csv$`).MatchString(got) {
+		t.Errorf("resolveConflictTime() = %q, want timestamp suffix", got)
+	}
+}
+
+func TestPlaceFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		replace    bool
+		destExists bool
+		want       string
+		wantErr    error
+	}{
+		{name: "new file", want: "source"},
+		{name: "new file replacing", replace: true, want: "source"},
+		{name: "existing file replaced", replace: true, destExists: true, want: "source"},
+		{name: "existing file kept", destExists: true, want: "dest", wantErr: errDestinationExists},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			fromFile, toFile := path.Join(dir, "from"), path.Join(dir, "to")
+			writeTestFile(t, fromFile, "source", time.Now())
+			if test.destExists {
+				writeTestFile(t, toFile, "dest", time.Now())
+			}
+			err := placeFile(fromFile, toFile, test.replace)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("placeFile() error = %v, want %v", err, test.wantErr)
+			}
+			if content, err := os.ReadFile(toFile); err != nil || string(content) != test.want {
+				t.Errorf("destination content = %q, %v, want %q", content, err, test.want)
+			}
+			// The source is left in place only when the destination is kept
+			if _, err := os.Stat(fromFile); (err == nil) != (test.wantErr != nil) {
+				t.Errorf("source file exists = %v, want %v", err == nil, test.wantErr != nil)
+			}
+		})
+	}
+}
+
+func TestLinkNoReplace(t *testing.T) {
+	dir := t.TempDir()
+	fromFile, toFile := path.Join(dir, "from"), path.Join(dir, "to")
+	writeTestFile(t, fromFile, "source", time.Now())
+	writeTestFile(t, toFile, "dest", time.Now())
+	if err := linkNoReplace(fromFile, toFile); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("linkNoReplace() error = %v, want %v", err, os.ErrExist)
+	}
+	if err := os.Remove(toFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := linkNoReplace(fromFile, toFile); err != nil {
+		t.Fatalf("linkNoReplace() error: %v", err)
+	}
+	if _, err := os.Stat(fromFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("source file not removed: %v", err)
+	}
+}
+
+// writeTestFile writes the content to the file, with the given modification time
+func writeTestFile(t *testing.T, filePath, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filePath, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
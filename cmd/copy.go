@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// tempCopySuffix marks the partial copies, renamed to their final name only when complete
+const tempCopySuffix = ".dirkeeper-tmp"
+
+// maxTempBaseLength truncates the file name in the temporary names, so that they stay
+// within the 255 bytes limit of most file systems
+const maxTempBaseLength = 200
+
+// staleTempCopyAge is the age after which a partial copy without the id of its process in the
+// name is considered left by an interrupted run, younger ones may still be written by another process
+const staleTempCopyAge = time.Hour
+
+// tempCopyPath returns a hidden temporary name of a copy, in the same directory of the destination.
+// The name is unique to the process and to the call, so that concurrent copies of the same file
+// never share their temporary file.
+func tempCopyPath(toFile string) string {
+	base := path.Base(toFile)
+	if len(base) > maxTempBaseLength {
+		base = strings.ToValidUTF8(base[:maxTempBaseLength], "")
+	}
+	var random [4]byte
+	_, _ = rand.Read(random[:])
+	return path.Join(path.Dir(toFile), fmt.Sprintf(".%v.%d-%x%v", base, os.Getpid(), random, tempCopySuffix))
+}
+
+func isTempCopy(fileName string) bool {
+	return strings.HasPrefix(fileName, ".") && strings.HasSuffix(fileName, tempCopySuffix)
+}
+
+// tempCopyPID returns the id of the process writing the temporary copy, from its name
+func tempCopyPID(fileName string) (int, bool) {
+	name := strings.TrimSuffix(fileName, tempCopySuffix)
+	pid, _, found := strings.Cut(name[strings.LastIndex(name, ".")+1:], "-")
+	if !found {
+		return 0, false
+	}
+	id, err := strconv.Atoi(pid)
+	return id, err == nil && id > 0
+}
+
+// processRunning reports whether the process with the given id is still running
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	defer func(process *os.Process) {
+		_ = process.Release()
+	}(process)
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, os.ErrPermission)
+}
+
+// copyFileAtomic copies the file to a temporary name in the destination directory, syncs it to disk
+// and renames it to the final name, so that the destination never contains a partial file.
+// Unless replace is set an existing destination file is never replaced.
+// The prepare function, if given, is called on the complete temporary copy before the rename,
+// with the checksum of the source content computed with the given algorithm.
+func copyFileAtomic(fromFile string, toFile string, replace bool, algorithm string, prepare func(tempFile string, sum []byte) error) ([]byte, error) {
+	tempFile := tempCopyPath(toFile)
+	sum, err := copyFileSynced(fromFile, tempFile, 0666, algorithm)
+	if err == nil && prepare != nil {
+		err = prepare(tempFile, sum)
+	}
+	if err == nil {
+		err = placeFile(tempFile, toFile, replace)
+	}
+	if err != nil {
+		if err := os.Remove(tempFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Warnln("Error removing incomplete copy", tempFile, err.Error())
+		}
+		return nil, err
+	}
+	return sum, nil
+}
+
+// copyFileSynced copies the file content to a new file and flushes it to disk, returning its checksum
+func copyFileSynced(fromFile string, toFile string, perm os.FileMode, algorithm string) ([]byte, error) {
+	from, err := os.Open(fromFile)
+	if err != nil {
+		return nil, err
+	}
+	defer func(from *os.File) {
+		err := from.Close()
+		if err != nil {
+			log.Warnln("Error closing source file", fromFile, err.Error())
+		}
+	}(from)
+
+	to, err := os.OpenFile(toFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return nil, err
+	}
+	hash := newChecksumHash(algorithm)
+	_, err = io.Copy(io.MultiWriter(to, hash), from)
+	if err == nil {
+		err = to.Sync()
+	}
+	if closeErr := to.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// writeFileAtomic writes the content to a temporary file in the destination directory, syncs it
+// to disk and renames it to the final name. Unless replace is set an existing destination file
+// is never replaced. The prepare function, if given, is called on the complete temporary file
+// before the rename.
+func writeFileAtomic(toFile string, replace bool, write func(to io.Writer) error, prepare func(tempFile string) error) error {
+	tempFile, err := writeTempFile(toFile, write)
+	if err != nil {
+		return err
+	}
+	if prepare != nil {
+		err = prepare(tempFile)
+	}
+	if err == nil {
+		err = placeFile(tempFile, toFile, replace)
+	}
+	if err != nil {
+		removeTempFile(tempFile)
+		return err
+	}
+	return nil
+}
+
+// writeTempFile writes the content to the temporary file of toFile and syncs it to disk,
+// returning the name of the complete temporary file
+func writeTempFile(toFile string, write func(to io.Writer) error) (string, error) {
+	tempFile := tempCopyPath(toFile)
+	to, err := os.OpenFile(tempFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return "", err
+	}
+	err = write(to)
+	if err == nil {
+		err = to.Sync()
+	}
+	if closeErr := to.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		removeTempFile(tempFile)
+		return "", err
+	}
+	return tempFile, nil
+}
+
+func removeTempFile(tempFile string) {
+	if err := os.Remove(tempFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warnln("Error removing incomplete file", tempFile, err.Error())
+	}
+}
+
+// cleanTempCopies removes the partial copies left in the destination directory by an interrupted run.
+// The copies of processes still running are kept, as they may still be in progress. Their modification
+// time is not reliable, since the copies get the time of the source file when preserving it.
+func cleanTempCopies(dirName string, recursive bool) error {
+	return readDirectory(dirName, defaultBatchSize, func(fileInfo os.FileInfo) error {
+		filePath := path.Join(dirName, fileInfo.Name())
+		if fileInfo.IsDir() {
+			if !recursive {
+				return nil
+			}
+			return cleanTempCopies(filePath, recursive)
+		}
+		// Temporary links are left as symlinks, the other temporary files are regular files
+		if !isTempCopy(fileInfo.Name()) || !(fileInfo.Mode().IsRegular() || fileInfo.Mode()&os.ModeSymlink != 0) {
+			return nil
+		}
+		if pid, ok := tempCopyPID(fileInfo.Name()); ok {
+			if processRunning(pid) {
+				log.Debugln("Skipping incomplete copy of running process", pid, filePath)
+				return nil
+			}
+		} else if time.Since(fileInfo.ModTime()) < staleTempCopyAge {
+			log.Debugln("Skipping recent incomplete copy", filePath)
+			return nil
+		}
+		log.Infoln("Removing incomplete copy", filePath)
+		if err := os.Remove(filePath); err != nil {
+			log.Warnln("Error removing incomplete copy", filePath, err.Error())
+		}
+		return nil
+	})
+}
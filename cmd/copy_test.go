@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTempCopyPID(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		want     int
+		wantOk   bool
+	}{
+		{name: "copy", fileName: ".report.csv.1234-0a1b2c3d.dirkeeper-tmp", want: 1234, wantOk: true},
+		{name: "name with dashes", fileName: ".db-1-2.sql.42-00ff00ff.dirkeeper-tmp", want: 42, wantOk: true},
+		{name: "without pid", fileName: ".report.csv.dirkeeper-tmp"},
+		{name: "invalid pid", fileName: ".report.csv.x1-0a1b2c3d.dirkeeper-tmp"},
+		{name: "zero pid", fileName: ".report.csv.0-0a1b2c3d.dirkeeper-tmp"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := tempCopyPID(test.fileName)
+			if ok != test.wantOk || got != test.want {
+				t.Errorf("tempCopyPID(%q) = %v, %v, want %v, %v", test.fileName, got, ok, test.want, test.wantOk)
+			}
+		})
+	}
+
+	tempFile := path.Base(tempCopyPath("/data/report.csv"))
+	if pid, ok := tempCopyPID(tempFile); !ok || pid != os.Getpid() {
+		t.Errorf("tempCopyPID(%q) = %v, %v, want %v", tempFile, pid, ok, os.Getpid())
+	}
+}
+
+func TestCleanTempCopies(t *testing.T) {
+	// The id of a process that completed
+	command := exec.Command("true")
+	if err := command.Run(); err != nil {
+		t.Skip("no completed process available:", err)
+	}
+	deadPID := command.Process.Pid
+	old := time.Now().Add(-2 * staleTempCopyAge)
+
+	tests := []struct {
+		name     string
+		fileName string
+		modTime  time.Time
+		removed  bool
+	}{
+		{name: "running process", fileName: fmt.Sprintf(".a.csv.%d-00000000%v", os.Getpid(), tempCopySuffix), modTime: time.Now()},
+		{name: "running process with preserved time", fileName: fmt.Sprintf(".a.csv.%d-00000000%v", os.Getpid(), tempCopySuffix), modTime: old},
+		{name: "completed process", fileName: fmt.Sprintf(".a.csv.%d-00000000%v", deadPID, tempCopySuffix), modTime: time.Now(), removed: true},
+		{name: "without pid recent", fileName: ".a.csv" + tempCopySuffix, modTime: time.Now()},
+		{name: "without pid old", fileName: ".a.csv" + tempCopySuffix, modTime: old, removed: true},
+		{name: "not a copy", fileName: "a.csv", modTime: old},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			filePath := path.Join(dir, test.fileName)
+			writeTestFile(t, filePath, "partial", test.modTime)
+			if err := cleanTempCopies(dir, false); err != nil {
+				t.Fatalf("cleanTempCopies() error: %v", err)
+			}
+			_, err := os.Stat(filePath)
+			if removed := errors.Is(err, os.ErrNotExist); removed != test.removed {
+				t.Errorf("file removed = %v, want %v", removed, test.removed)
+			}
+		})
+	}
+}
+
+func TestCopyFileAtomic(t *testing.T) {
+	errPrepare := errors.New("prepare failed")
+	tests := []struct {
+		name       string
+		replace    bool
+		destExists bool
+		prepareErr error
+		want       string
+		wantErr    error
+	}{
+		{name: "new file", want: "source"},
+		{name: "existing file replaced", replace: true, destExists: true, want: "source"},
+		{name: "existing file kept", destExists: true, want: "dest", wantErr: errDestinationExists},
+		{name: "prepare failed", prepareErr: errPrepare, wantErr: errPrepare},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			fromFile, toFile := path.Join(dir, "from"), path.Join(dir, "to")
+			writeTestFile(t, fromFile, "source", time.Now())
+			if test.destExists {
+				writeTestFile(t, toFile, "dest", time.Now())
+			}
+			var prepared string
+			_, err := copyFileAtomic(fromFile, toFile, test.replace, "sha256", func(tempFile string, sum []byte) error {
+				// The temporary copy is complete, and not yet in its final place
+				if content, err := os.ReadFile(tempFile); err != nil || string(content) != "source" {
+					t.Errorf("temporary copy content = %q, %v", content, err)
+				}
+				prepared = tempFile
+				return test.prepareErr
+			})
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("copyFileAtomic() error = %v, want %v", err, test.wantErr)
+			}
+			if !isTempCopy(path.Base(prepared)) {
+				t.Errorf("prepare called on %v, want a temporary copy", prepared)
+			}
+			content, err := os.ReadFile(toFile)
+			if len(test.want) == 0 {
+				if !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("destination file created: %v", err)
+				}
+			} else if err != nil || string(content) != test.want {
+				t.Errorf("destination content = %q, %v, want %q", content, err, test.want)
+			}
+			// No temporary copy is left behind
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				if strings.HasSuffix(entry.Name(), tempCopySuffix) {
+					t.Errorf("temporary copy %v left", entry.Name())
+				}
+			}
+		})
+	}
+}
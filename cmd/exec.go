@@ -0,0 +1,244 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultCommandTimeout is the timeout in seconds of the commands when not configured, so that
+// a hanging command never blocks the processing of the other files
+const defaultCommandTimeout = 300
+
+// maxCommandOutput is the size of the output of a command kept for the log, only the last part
+// of longer outputs is logged
+const maxCommandOutput = 64 * 1024
+
+// execOptions configures the EXEC action
+type execOptions struct {
+	// command is the command line executed on the file, already rendered from the templates
+	command []string
+	// timeout kills the command when it runs longer
+	timeout time.Duration
+	// onSuccess and onFailure are the actions executed on the file after the command
+	onSuccess followUpAction
+	onFailure followUpAction
+}
+
+// followUpAction is an action executed on the file depending on the exit code of the command
+type followUpAction struct {
+	action  string
+	destDir string
+}
+
+// newCommandArgs parses the templates of the command arguments
+func newCommandArgs(args []string) ([]*fileTemplate, error) {
+	templates := make([]*fileTemplate, len(args))
+	for i, arg := range args {
+		tmpl, err := newFileTemplate(arg)
+		if err != nil {
+			return nil, err
+		}
+		templates[i] = tmpl
+	}
+	return templates, nil
+}
+
+// renderCommand returns the command line executed on the file. Without arguments
+// the path of the file is passed as the only argument.
+func renderCommand(command string, args []*fileTemplate, data fileTemplateData) ([]string, error) {
+	if len(args) == 0 {
+		return []string{command, data.Path}, nil
+	}
+	commandLine := make([]string, 1, len(args)+1)
+	commandLine[0] = command
+	for _, arg := range args {
+		var value string
+		if arg != nil {
+			var err error
+			if value, err = arg.render(data); err != nil {
+				return nil, err
+			}
+		}
+		commandLine = append(commandLine, value)
+	}
+	return commandLine, nil
+}
+
+// execCommand runs the command, logging its output, and fails when the command
+// exits with a non zero code or does not complete within the timeout
+func execCommand(commandLine []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	stdout, stderr := &tailBuffer{size: maxCommandOutput}, &tailBuffer{size: maxCommandOutput}
+	command := exec.CommandContext(ctx, commandLine[0], commandLine[1:]...)
+	command.Stdout = stdout
+	command.Stderr = stderr
+	// Processes started by the command may keep the output open after it is killed
+	command.WaitDelay = time.Second
+	err := command.Run()
+
+	name := path.Base(commandLine[0])
+	logCommandOutput(name, stdout, log.InfoLevel)
+	logCommandOutput(name, stderr, log.WarnLevel)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("command %v timed out after %v", name, timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("command %v failed: %w", name, err)
+	}
+	return nil
+}
+
+func logCommandOutput(name string, output *tailBuffer, level log.Level) {
+	if output.truncated {
+		log.StandardLogger().Logf(level, "[%v] output truncated, only the last %v bytes are logged", name, output.size)
+	}
+	for _, line := range strings.Split(string(output.data), "\n") {
+		if line := strings.TrimRight(line, "\r"); len(line) > 0 {
+			log.StandardLogger().Logf(level, "[%v] %v", name, line)
+		}
+	}
+}
+
+// tailBuffer keeps the last bytes written to it, up to its size
+type tailBuffer struct {
+	size      int
+	data      []byte
+	truncated bool
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	written := len(p)
+	if len(p) > b.size {
+		p = p[len(p)-b.size:]
+		b.truncated = true
+	}
+	if extra := len(b.data) + len(p) - b.size; extra > 0 {
+		b.data = append(b.data[:0], b.data[extra:]...)
+		b.truncated = true
+	}
+	b.data = append(b.data, p...)
+	return written, nil
+}
+
+// execFile runs the command on the file and then executes the follow-up action
+// matching its outcome. The error of the command is returned even when the
+// follow-up action succeeds.
+func execFile(sourceDir, fileName string, opts processOptions) error {
+	log.Infof("Executing %v on file %v", strings.Join(opts.exec.command, " "), fileName)
+	err := execCommand(opts.exec.command, opts.exec.timeout)
+	followUp := opts.exec.onSuccess
+	if err != nil {
+		log.Errorf("Error executing command on file %v: %v", fileName, err.Error())
+		followUp = opts.exec.onFailure
+	}
+	if len(followUp.action) == 0 {
+		return err
+	}
+
+	followUpOpts := opts
+	followUpOpts.destName = ""
+	followUpOpts.exec = execOptions{}
+	followUpOpts.permissions = permissionOptions{}
+	if followUpErr := processFile(followUp.action, sourceDir, followUp.destDir, fileName, followUpOpts); err == nil && !errors.Is(followUpErr, errFileSkipped) {
+		err = followUpErr
+	}
+	return err
+}
+
+// newExecPlanOptions records the command line, as a JSON array, and the follow-up actions in the plan options
+func newExecPlanOptions(commandLine []string, timeout int, onSuccess, onFailure followUpAction) map[string]string {
+	command, _ := json.Marshal(commandLine)
+	options := map[string]string{"command": string(command)}
+	options["timeout"] = strconv.Itoa(timeout)
+	if len(onSuccess.action) > 0 {
+		options["onSuccess"] = strings.ToLower(onSuccess.action)
+		options["onSuccessDest"] = onSuccess.destDir
+	}
+	if len(onFailure.action) > 0 {
+		options["onFailure"] = strings.ToLower(onFailure.action)
+		options["onFailureDest"] = onFailure.destDir
+	}
+	return options
+}
+
+// execPlanOptions restores the options of the EXEC action from the plan options
+func execPlanOptions(options map[string]string) (execOptions, error) {
+	opts := execOptions{timeout: defaultCommandTimeout * time.Second}
+	if err := json.Unmarshal([]byte(options["command"]), &opts.command); err != nil {
+		return opts, fmt.Errorf("invalid command: %w", err)
+	}
+	if len(opts.command) == 0 {
+		return opts, errors.New("missing command")
+	}
+	if len(options["timeout"]) > 0 {
+		timeout, err := strconv.Atoi(options["timeout"])
+		if err != nil {
+			return opts, fmt.Errorf("invalid timeout: %w", err)
+		}
+		if timeout <= 0 {
+			return opts, errors.New("invalid timeout")
+		}
+		opts.timeout = time.Duration(timeout) * time.Second
+	}
+	opts.onSuccess = followUpAction{action: options["onSuccess"], destDir: options["onSuccessDest"]}
+	opts.onFailure = followUpAction{action: options["onFailure"], destDir: options["onFailureDest"]}
+	return opts, nil
+}
+
+// checkFollowUpAction verifies the action executed after the command and its destination directory
+func checkFollowUpAction(action, destDir string) error {
+	switch strings.ToUpper(action) {
+	case "", "DELETE":
+		return nil
+	case "COPY", "MOVE", "COPY-DELETE":
+		if len(destDir) == 0 {
+			log.Errorln("Missing destination directory of follow-up action", action)
+			return errors.New("missing destination directory")
+		}
+	case "COMPRESS":
+		// Without destination files are compressed in place
+		if len(destDir) == 0 {
+			return nil
+		}
+	default:
+		log.Errorln("Invalid follow-up action", action)
+		return errors.New("invalid follow-up action")
+	}
+	dirInfo, err := os.Stat(destDir)
+	if err != nil {
+		log.Errorln("Invalid directory", err)
+		return err
+	}
+	if !dirInfo.IsDir() {
+		log.Errorln("Destination of follow-up action must be a valid directory")
+		return errors.New("invalid destination directory")
+	}
+	return nil
+}
+
+func checkExecOptions(command string, args []string, timeout int) error {
+	if len(command) == 0 {
+		log.Errorln("Missing command of exec action")
+		return errors.New("missing command")
+	}
+	if _, err := newCommandArgs(args); err != nil {
+		log.Errorln("Invalid command argument template", err.Error())
+		return err
+	}
+	if timeout <= 0 {
+		log.Errorln("Invalid timeout, positive number of seconds expected")
+		return errors.New("invalid timeout")
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRenderCommand(t *testing.T) {
+	fileTime := time.Date(2024, time.March, 5, 14, 30, 0, 0, time.Local)
+	data := newFileTemplateData("/data", "/data/in", "report.csv", fileTime, []string{"report.csv", "report"})
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "file path by default", want: []string{"upload", "/data/in/report.csv"}},
+		{name: "plain arguments", args: []string{"-v", "--retry=3"}, want: []string{"upload", "-v", "--retry=3"}},
+		{name: "templates", args: []string{"--name={{.Base}}", "{{.Path}}"}, want: []string{"upload", "--name=report", "/data/in/report.csv"}},
+		{name: "date and capture group", args: []string{"{{.Year}}{{.Month}}{{.Day}}", "{{index .Groups 1}}"}, want: []string{"upload", "20240305", "report"}},
+		{name: "spaces kept in one argument", args: []string{"{{.Dir}} {{.Name}}"}, want: []string{"upload", "in report.csv"}},
+		{name: "empty argument", args: []string{"-v", ""}, want: []string{"upload", "-v", ""}},
+		{name: "absolute paths allowed", args: []string{"/tmp/{{.Name}}"}, want: []string{"upload", "/tmp/report.csv"}},
+		{name: "missing field", args: []string{"{{.Missing}}"}, wantErr: true},
+		{name: "missing capture group", args: []string{"{{index .Groups 2}}"}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args, err := newCommandArgs(test.args)
+			if err != nil {
+				t.Fatalf("newCommandArgs(%q) error: %v", test.args, err)
+			}
+			got, err := renderCommand("upload", args, data)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("renderCommand(%q) = %q, want error", test.args, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("renderCommand(%q) error: %v", test.args, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("renderCommand(%q) = %q, want %q", test.args, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// fileFilter matches file names by prefix, suffix, regular expression or glob,
+// the same matchers used by match and watch rules
+type fileFilter struct {
+	prefixes []string
+	suffixes []string
+	patterns []string
+	globs    []string
+	regexps  []*regexp.Regexp
+}
+
+func (f fileFilter) isEmpty() bool {
+	return len(f.prefixes) == 0 && len(f.suffixes) == 0 && len(f.patterns) == 0 && len(f.globs) == 0
+}
+
+// match returns the description of the first matcher matching the file name
+func (f fileFilter) match(fileName string) (string, bool) {
+	for _, prefix := range f.prefixes {
+		if strings.HasPrefix(fileName, prefix) {
+			return "prefix " + prefix, true
+		}
+	}
+	for _, suffix := range f.suffixes {
+		if strings.HasSuffix(fileName, suffix) {
+			return "suffix " + suffix, true
+		}
+	}
+	for _, re := range f.regexps {
+		if re.MatchString(fileName) {
+			return "pattern " + re.String(), true
+		}
+	}
+	for _, glob := range f.globs {
+		if match, _ := filepath.Match(glob, fileName); match {
+			return "glob " + glob, true
+		}
+	}
+	return "", false
+}
+
+// compile prepares the regular expressions of a filter already checked with checkFileFilter
+func (f *fileFilter) compile() {
+	f.regexps = make([]*regexp.Regexp, len(f.patterns))
+	for i, p := range f.patterns {
+		f.regexps[i], _ = regexp.Compile(p)
+	}
+}
+
+// isFileIncluded reports whether the file matches the include filter (if any)
+// and does not match the exclude filter, together with the reason of the exclusion
+func isFileIncluded(fileName string, include, exclude fileFilter) (string, bool) {
+	if matcher, ok := exclude.match(fileName); ok {
+		return "matches exclude " + matcher, false
+	}
+	if include.isEmpty() {
+		return "", true
+	}
+	if _, ok := include.match(fileName); ok {
+		return "", true
+	}
+	return "does not match include filters", false
+}
+
+func checkFileFilter(f fileFilter) error {
+	for _, p := range f.patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			log.Errorln("Invalid pattern regexp", p, err.Error())
+			return err
+		}
+	}
+	for _, glob := range f.globs {
+		if _, err := filepath.Match(glob, ""); err != nil {
+			log.Errorln("Invalid glob", glob, err.Error())
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"time"
+)
+
+// gfsOptions configures the grandfather-father-son retention policy: all the
+// files of the last days are kept, then one per day, one per week and one per
+// month for the configured number of weeks, months and years
+type gfsOptions struct {
+	gfsDays   int
+	gfsWeeks  int
+	gfsMonths int
+	gfsYears  int
+}
+
+func (opts gfsOptions) enabled() bool {
+	return opts.gfsDays > 0 || opts.gfsWeeks > 0 || opts.gfsMonths > 0 || opts.gfsYears > 0
+}
+
+type gfsTier struct {
+	since  time.Time
+	bucket func(date time.Time) string
+}
+
+// selectGFSExpiredFiles marks the candidates not retained by any tier of the policy,
+// dating the files with the time source of the run
+func selectGFSExpiredFiles(candidates []cleanCandidate, opts gfsOptions) []bool {
+	expired := make([]bool, len(candidates))
+	if !opts.enabled() {
+		return expired
+	}
+
+	type datedCandidate struct {
+		index int
+		date  time.Time
+	}
+	dated := make([]datedCandidate, len(candidates))
+	for i, candidate := range candidates {
+		dated[i] = datedCandidate{index: i, date: candidate.fileTime}
+	}
+	sort.SliceStable(dated, func(i, j int) bool {
+		return dated[i].date.After(dated[j].date)
+	})
+
+	now := time.Now()
+	tiers := []gfsTier{
+		{since: now.AddDate(0, 0, -opts.gfsDays)},
+		{since: now.AddDate(0, 0, -7*opts.gfsWeeks), bucket: func(date time.Time) string {
+			return date.Format("2006-01-02")
+		}},
+		{since: now.AddDate(0, -opts.gfsMonths, 0), bucket: func(date time.Time) string {
+			year, week := date.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{since: now.AddDate(-opts.gfsYears, 0, 0), bucket: func(date time.Time) string {
+			return date.Format("2006-01")
+		}},
+	}
+
+	retained := map[int]bool{}
+	for _, tier := range tiers {
+		seen := map[string]bool{}
+		for _, d := range dated {
+			if !d.date.After(tier.since) {
+				break
+			}
+			// Tiers with buckets only keep the newest file of each bucket
+			if tier.bucket != nil {
+				bucket := tier.bucket(d.date)
+				if seen[bucket] {
+					continue
+				}
+				seen[bucket] = true
+			}
+			retained[d.index] = true
+		}
+	}
+
+	for _, d := range dated {
+		expired[d.index] = !retained[d.index]
+	}
+	return expired
+}
+
+func checkGFSOptions(opts gfsOptions, maxAgeDays int) error {
+	if opts.gfsDays < 0 || opts.gfsWeeks < 0 || opts.gfsMonths < 0 || opts.gfsYears < 0 {
+		log.Errorln("Invalid grandfather-father-son policy, zero or positive numbers expected")
+		return errors.New("invalid gfs policy")
+	}
+	if opts.enabled() && maxAgeDays > 0 {
+		log.Errorln("Max age and grandfather-father-son policy cannot be used together")
+		return errors.New("conflicting max-age and gfs policy")
+	}
+	return nil
+}
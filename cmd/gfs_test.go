@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSelectGFSExpiredFiles(t *testing.T) {
+	now := time.Now()
+	// Noon avoids the files of the same day falling on different days
+	day := func(days int) time.Time {
+		return time.Date(now.Year(), now.Month(), now.Day()-days, 12, 0, 0, 0, time.Local)
+	}
+	monday := day(35 + (int(now.Weekday())+6)%7)
+	month := time.Date(now.Year(), now.Month()-6, 10, 12, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name  string
+		opts  gfsOptions
+		times []time.Time
+		want  []bool
+	}{
+		{
+			name:  "disabled",
+			opts:  gfsOptions{},
+			times: []time.Time{day(1), day(1000)},
+			want:  []bool{false, false},
+		},
+		{
+			name:  "all files of the last days",
+			opts:  gfsOptions{gfsDays: 2},
+			times: []time.Time{now.Add(-time.Hour), now.Add(-2 * time.Hour), day(3)},
+			want:  []bool{false, false, true},
+		},
+		{
+			name:  "newest file of each day",
+			opts:  gfsOptions{gfsWeeks: 1},
+			times: []time.Time{day(3).Add(-time.Hour), day(3), day(4), day(10)},
+			want:  []bool{true, false, false, true},
+		},
+		{
+			name:  "newest file of each week",
+			opts:  gfsOptions{gfsMonths: 2},
+			times: []time.Time{monday, monday.AddDate(0, 0, 1), monday.AddDate(0, 0, -7), day(100)},
+			want:  []bool{true, false, false, true},
+		},
+		{
+			name:  "newest file of each month",
+			opts:  gfsOptions{gfsYears: 1},
+			times: []time.Time{month, month.AddDate(0, 0, 1), month.AddDate(0, -1, 0), day(400)},
+			want:  []bool{true, false, false, true},
+		},
+		{
+			name:  "tiers combined",
+			opts:  gfsOptions{gfsDays: 1, gfsWeeks: 1, gfsYears: 1},
+			times: []time.Time{now.Add(-time.Minute), now.Add(-2 * time.Minute), day(3), day(3).Add(-time.Hour), month, day(400)},
+			want:  []bool{false, false, false, true, false, true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			candidates := make([]cleanCandidate, len(test.times))
+			for i, fileTime := range test.times {
+				candidates[i] = cleanCandidate{fileTime: fileTime}
+			}
+			if got := selectGFSExpiredFiles(candidates, test.opts); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("selectGFSExpiredFiles() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
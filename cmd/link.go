@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// linkFile creates a symbolic link, or a hard link, to the file. When replace is set the link is
+// created with a temporary name and then renamed, atomically replacing an existing file with the
+// same name, otherwise the link is created directly and an existing file is never replaced.
+func linkFile(fromFile string, toFile string, hard bool, replace bool) error {
+	link, target := os.Link, fromFile
+	if !hard {
+		// Symbolic links always point to the absolute path of the file, wherever they are created
+		var err error
+		if target, err = filepath.Abs(fromFile); err != nil {
+			return err
+		}
+		link = os.Symlink
+	}
+	if replace {
+		return replaceWithLink(target, toFile, link)
+	}
+	if err := link(target, toFile); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%w: %v", errDestinationExists, toFile)
+		}
+		return err
+	}
+	return nil
+}
+
+func replaceWithLink(target string, linkPath string, link func(string, string) error) error {
+	tempLink := tempCopyPath(linkPath)
+	if err := link(target, tempLink); err != nil {
+		return err
+	}
+	if err := os.Rename(tempLink, linkPath); err != nil {
+		if err := os.Remove(tempLink); err != nil {
+			log.Warnln("Error removing temporary link", tempLink, err.Error())
+		}
+		return err
+	}
+	return nil
+}
+
+// updateLatestLink points the link to the file, unless the link already points to a more recent file
+func updateLatestLink(linkPath string, fromFile string, hard bool) error {
+	fileInfo, err := os.Stat(fromFile)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(linkPath), 0755); err != nil {
+		return err
+	}
+	if latestInfo, err := os.Stat(linkPath); err == nil {
+		if os.SameFile(fileInfo, latestInfo) {
+			return nil
+		}
+		if latestInfo.ModTime().After(fileInfo.ModTime()) {
+			log.Debugf("Latest link %v already points to a more recent file", linkPath)
+			return nil
+		}
+	}
+	log.Infof("Updating latest link %v to file %v", linkPath, filepath.Base(fromFile))
+	return linkFile(fromFile, linkPath, hard, true)
+}
+
+func checkLatestLink(latestLink string) error {
+	if len(latestLink) == 0 {
+		return nil
+	}
+	linkPath := path.Clean(latestLink)
+	if path.IsAbs(linkPath) || linkPath == "." || linkPath == ".." || strings.HasPrefix(linkPath, "../") {
+		log.Errorln("Invalid latest link, path inside the destination directory expected", latestLink)
+		return errors.New("invalid latest link")
+	}
+	return nil
+}
@@ -2,37 +2,122 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"io"
-	"io/fs"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"syscall"
 	"time"
 )
 
 func init() {
 	MatchCmd.PersistentFlags().StringVarP(&matchCmdParams.dirName, "directory", "d", "", "Base directory")
 	MatchCmd.PersistentFlags().StringVar(&matchCmdParams.destDir, "dest-dir", "", "Destination directory")
-	MatchCmd.PersistentFlags().StringVarP(&matchCmdParams.action, "action", "a", "", "Action to execute (copy, copy-delete, move, delete")
+	MatchCmd.PersistentFlags().StringVar(&matchCmdParams.destTemplateText, "dest-template", "", "Template of the destination path relative to the destination directory (e.g. {{.Year}}/{{.Month}}/{{.Name}})")
+	MatchCmd.PersistentFlags().StringVar(&matchCmdParams.renameTemplateText, "rename-template", "", "Template of the new file name of the rename action (e.g. {{.Base | lower}}-{{timestamp \"20060102\" .Time}}{{.Ext}})")
+	MatchCmd.PersistentFlags().StringVar(&matchCmdParams.latestLink, "latest-link", "", "Path of a link, relative to the destination directory, always pointing to the most recent file of the link and hardlink actions")
+	MatchCmd.PersistentFlags().StringVar(&matchCmdParams.conflict, "conflict", "", "Policy when the destination file exists (fail, skip, overwrite, rename, rename-timestamp, keep-newer), overwrite by default, fail for the rename action")
+	MatchCmd.PersistentFlags().StringVar(&matchCmdParams.verify, "verify", "", "Verify the copies with the given checksum algorithm (sha256, xxhash) before deleting the source")
+	MatchCmd.PersistentFlags().BoolVar(&matchCmdParams.checksumFile, "checksum-file", false, "Write the checksum of the copied files in a file next to them")
+	MatchCmd.PersistentFlags().StringSliceVar(&matchCmdParams.preserveNames, "preserve", []string{}, "List of metadata of the copied files to preserve (mode, timestamps, ownership, xattrs, all)")
+	MatchCmd.PersistentFlags().StringVar(&matchCmdParams.compression, "compression", defaultCompression, "Compression format of the compress action (gzip, zstd)")
+	MatchCmd.PersistentFlags().StringVar(&matchCmdParams.maxExtractSize, "max-extract-size", defaultMaxExtractSize, "Maximum total size of the files extracted from each archive by the extract action (e.g. 10GB)")
+	MatchCmd.PersistentFlags().StringVar(&matchCmdParams.command, "command", "", "Command executed on the matching files by the exec action")
+	MatchCmd.PersistentFlags().StringArrayVar(&matchCmdParams.commandArgs, "arg", []string{}, "Argument of the command, supports file templates (e.g. {{.Path}}), the file path if not specified")
+	MatchCmd.PersistentFlags().IntVar(&matchCmdParams.timeout, "timeout", defaultCommandTimeout, "Timeout of the command in seconds")
+	MatchCmd.PersistentFlags().StringVar(&matchCmdParams.onSuccess.action, "on-success", "", "Action executed on the file when the command succeeds (copy, copy-delete, move, delete, compress)")
+	MatchCmd.PersistentFlags().StringVar(&matchCmdParams.onSuccess.destDir, "on-success-dest", "", "Destination directory of the on-success action")
+	MatchCmd.PersistentFlags().StringVar(&matchCmdParams.onFailure.action, "on-failure", "", "Action executed on the file when the command fails (copy, copy-delete, move, delete, compress)")
+	MatchCmd.PersistentFlags().StringVar(&matchCmdParams.onFailure.destDir, "on-failure-dest", "", "Destination directory of the on-failure action")
+	MatchCmd.PersistentFlags().StringVar(&matchCmdParams.mode, "mode", "", "Octal permission mode set on the processed files (copy, copy-delete, move, compress, rename, permissions) (e.g. 0640)")
+	MatchCmd.PersistentFlags().StringVar(&matchCmdParams.owner, "owner", "", "Owner, name or uid, set on the processed files (copy, copy-delete, move, compress, rename, permissions)")
+	MatchCmd.PersistentFlags().StringVar(&matchCmdParams.group, "group", "", "Group, name or gid, set on the processed files (copy, copy-delete, move, compress, rename, permissions)")
+	MatchCmd.PersistentFlags().StringVarP(&matchCmdParams.action, "action", "a", "", "Action to execute (copy, copy-delete, move, delete, compress, extract, exec, rename, link, hardlink, permissions)")
 	MatchCmd.PersistentFlags().StringSliceVar(&matchCmdParams.prefixes, "prefix", []string{}, "List of file name prefixes")
 	MatchCmd.PersistentFlags().StringSliceVar(&matchCmdParams.suffixes, "suffix", []string{}, "List of file name suffixes")
 	MatchCmd.PersistentFlags().StringSliceVar(&matchCmdParams.patterns, "pattern", []string{}, "List of file name patterns")
 	MatchCmd.PersistentFlags().BoolVar(&matchCmdParams.dryRun, "dry-run", false, "Do not execute action")
+	MatchCmd.PersistentFlags().StringVarP(&matchCmdParams.output, "output", "o", "text", "Output format of the plan of actions (text, json, csv)")
 	MatchCmd.PersistentFlags().IntVar(&matchCmdParams.maxAge, "max-age", 0, "Max file age in minutes")
+	MatchCmd.PersistentFlags().StringVar(&matchCmdParams.timeSourceName, "time-source", "mtime", "Timestamp used for the file age (mtime, atime, ctime, birth, name)")
+	MatchCmd.PersistentFlags().StringVar(&matchCmdParams.timePattern, "time-pattern", "", "Pattern on file name whose first capture group contains the file date, for the name time source")
+	MatchCmd.PersistentFlags().StringVar(&matchCmdParams.timeLayout, "time-layout", "2006-01-02", "Layout of the date in the file name, in Go time format")
+	MatchCmd.PersistentFlags().StringVar(&matchCmdParams.trashDir, "trash-dir", "", "Move deleted files to the given trash directory")
+	MatchCmd.PersistentFlags().IntVar(&matchCmdParams.trashRetentionDays, "trash-retention", 0, "Purge files trashed more than the given number of days ago (0 means never)")
+	MatchCmd.PersistentFlags().BoolVarP(&matchCmdParams.recursive, "recursive", "r", false, "Match files in subdirectories recursively, recreating the directory structure under the destination directory")
+	MatchCmd.PersistentFlags().IntVar(&matchCmdParams.maxDepth, "max-depth", 0, "Maximum depth of subdirectories to match (0 means unlimited)")
+	MatchCmd.PersistentFlags().StringSliceVar(&matchCmdParams.includeDirs, "include-dir", []string{}, "List of glob patterns of subdirectory names to include")
+	MatchCmd.PersistentFlags().StringSliceVar(&matchCmdParams.excludeDirs, "exclude-dir", []string{}, "List of glob patterns of subdirectory names to exclude")
+	MatchCmd.PersistentFlags().BoolVar(&matchCmdParams.followSymlinks, "follow-symlinks", false, "Follow symlinked directories")
+	MatchCmd.PersistentFlags().BoolVar(&matchCmdParams.matchPath, "match-path", false, "Match prefixes, suffixes and patterns against the path relative to the base directory instead of the file name")
+	MatchCmd.PersistentFlags().IntVar(&matchCmdParams.batchSize, "batch-size", defaultBatchSize, "Number of directory entries read at a time")
+	MatchCmd.PersistentFlags().IntVar(&matchCmdParams.progressEvery, "progress-every", 0, "Log the scan progress every given number of files (0 means never)")
+	MatchCmd.PersistentFlags().BoolVar(&matchCmdParams.pruneEmpty, "prune-empty", false, "Remove directories left empty by move or delete actions")
+	MatchCmd.PersistentFlags().IntVar(&matchCmdParams.pruneMinAge, "prune-min-age", 0, "Minimum age of the empty directories to remove in minutes")
+	MatchCmd.PersistentFlags().StringSliceVar(&matchCmdParams.pruneProtect, "prune-protect", []string{}, "List of directories never removed by pruning")
 }
 
 type matchCmdParamsType struct {
-	dirName  string
-	destDir  string
+	dirName string
+	destDir string
+
+	destTemplateText string
+	destTemplate     *fileTemplate
+	conflict         string
+
+	renameTemplateText string
+	renameTemplate     *fileTemplate
+	renamed            map[string]bool
+	latestLink         string
+
+	checksumOptions
+	preserveNames []string
+	preserve      preserveOptions
+	compression   string
+
+	maxExtractSize  string
+	maxExtractBytes uint64
+
+	command          string
+	commandArgs      []string
+	commandTemplates []*fileTemplate
+	timeout          int
+	onSuccess        followUpAction
+	onFailure        followUpAction
+
+	mode        string
+	owner       string
+	group       string
+	permissions permissionOptions
+
 	action   string
 	prefixes []string
 	suffixes []string
 	patterns []string
 	maxAge   int
 	dryRun   bool
+
+	timeSourceName string
+	timePattern    string
+	timeLayout     string
+	timeSource     timeSource
+
+	pruneEmpty   bool
+	pruneMinAge  int
+	pruneProtect []string
+
+	trashDir           string
+	trashRetentionDays int
+
+	output string
+	plan   *planWriter
+
+	matchPath bool
+	walkOptions
 }
 
 var matchCmdParams = matchCmdParamsType{}
@@ -49,22 +134,20 @@ func MatchFiles(params matchCmdParamsType) error {
 	if err := checkMatchParameters(params); err != nil {
 		return err
 	}
-
-	directory, err := os.Open(params.dirName)
-	if err != nil {
-		return err
-	}
-	defer func(directory *os.File) {
-		err := directory.Close()
-		if err != nil {
-			log.Warnln("Error closing directory", params.dirName, err.Error())
+	params.timeSource, _ = newTimeSource(params.timeSourceName, params.timePattern, params.timeLayout)
+	if len(params.conflict) == 0 {
+		params.conflict = defaultConflictPolicy
+		if strings.ToUpper(params.action) == "RENAME" {
+			params.conflict = defaultRenameConflictPolicy
 		}
-	}(directory)
-
-	dirContent, err := directory.Readdir(-1)
-	if err != nil {
-		return err
 	}
+	params.destTemplate, _ = newFileTemplate(params.destTemplateText)
+	params.renameTemplate, _ = newFileTemplate(params.renameTemplateText)
+	params.renamed = map[string]bool{}
+	params.permissions, _ = newPermissionOptions(params.mode, params.owner, params.group)
+	params.preserve, _ = newPreserveOptions(params.preserveNames)
+	params.maxExtractBytes, _ = parseMaxExtractSize(params.maxExtractSize)
+	params.commandTemplates, _ = newCommandArgs(params.commandArgs)
 
 	var patterns = make([]*regexp.Regexp, len(params.patterns))
 	for i, p := range params.patterns {
@@ -72,64 +155,261 @@ func MatchFiles(params matchCmdParamsType) error {
 		patterns[i] = re
 	}
 
+	var pruner *dirPruner
+	if params.pruneEmpty {
+		pruner = newDirPruner(params.dirName, time.Duration(params.pruneMinAge)*time.Minute, params.pruneProtect, params.dryRun)
+	}
+
+	plan, err := newPlanWriter(params.output, "match", params.dryRun, os.Stdout)
+	if err != nil {
+		return err
+	}
+	params.plan = plan
+
+	if params.recursive && len(params.destDir) > 0 {
+		// Files copied or moved inside the base directory must not be matched again
+		if realDir, err := filepath.EvalSymlinks(params.destDir); err == nil {
+			params.skipDirs = append(params.skipDirs, realDir)
+		}
+	}
+
+	if len(params.destDir) > 0 && !params.dryRun {
+		if err := cleanTempCopies(params.destDir, params.recursive || params.destTemplate != nil); err != nil {
+			log.Warnln("Error removing incomplete copies", params.destDir, err.Error())
+		}
+	}
+
 	log.Infof("Scanning directory %v for matches", params.dirName)
-	for _, fileInfo := range dirContent {
-		if err := checkAndProcessFile(params, fileInfo, patterns); err != nil {
-			return err
+	err = walkDirectory(params.dirName, params.walkOptions, func(dirName string, fileInfo os.FileInfo) error {
+		return checkAndProcessFile(params, dirName, fileInfo, patterns, pruner)
+	})
+	if err != nil {
+		if err := plan.close(); err != nil {
+			log.Warnln("Error writing plan", err.Error())
 		}
+		return err
 	}
 	log.Infoln("Directory", params.dirName, "scan complete")
+	if err := plan.close(); err != nil {
+		return err
+	}
+	if err := pruner.prune(); err != nil {
+		return err
+	}
+
+	if len(params.trashDir) > 0 && params.trashRetentionDays > 0 {
+		return purgeTrash(params.trashDir, params.trashRetentionDays, params.dryRun)
+	}
 	return nil
 }
 
-func checkAndProcessFile(params matchCmdParamsType, fileInfo os.FileInfo, patterns []*regexp.Regexp) error {
+func checkAndProcessFile(params matchCmdParamsType, dirName string, fileInfo os.FileInfo, patterns []*regexp.Regexp, pruner *dirPruner) error {
 	fileName := fileInfo.Name()
 	if fileInfo.IsDir() {
 		log.Infoln("Skipping directory", fileName)
 		return nil
 	}
-	if fileInfo.Mode() == fs.ModeSymlink {
-		log.Infoln("Skipping symlink", fileName)
+	if isTempCopy(fileName) {
+		log.Debugln("Skipping incomplete copy", fileName)
 		return nil
 	}
 
-	if params.maxAge > 0 && int(time.Now().Sub(fileInfo.ModTime()).Minutes()) > params.maxAge {
+	filePath := path.Join(dirName, fileName)
+	if params.renamed[filePath] {
+		log.Debugln("Skipping renamed file", fileName)
+		return nil
+	}
+	if exceedsMaxAge(filePath, fileInfo, params.timeSource, params.maxAge) {
 		return nil
 	}
 
+	// The file name, or the path relative to the base directory, is matched against the rules
+	matchName := fileName
+	if params.matchPath {
+		matchName = relativePath(params.dirName, filePath)
+	}
+
 	for _, prefix := range params.prefixes {
-		if strings.HasPrefix(fileName, prefix) {
-			log.Infoln("File", fileName, "matches prefix", prefix)
-			if !params.dryRun {
-				if err := processFile(params.action, params.dirName, params.destDir, fileName); err != nil {
-					log.Errorf("Error processing file %v: %v", fileName, err.Error())
-				}
+		if strings.HasPrefix(matchName, prefix) {
+			log.Infoln("File", matchName, "matches prefix", prefix)
+			if err := processMatchedFile(params, dirName, fileInfo, "match prefix "+prefix, []string{matchName}, pruner); err != nil {
+				return err
 			}
 		}
 	}
 	for _, suffix := range params.suffixes {
-		if strings.HasSuffix(fileName, suffix) {
-			log.Infoln("File", fileName, "matches suffix", suffix)
-			if !params.dryRun {
-				if err := processFile(params.action, params.dirName, params.destDir, fileName); err != nil {
-					log.Errorf("Error processing file %v: %v", fileName, err.Error())
-				}
+		if strings.HasSuffix(matchName, suffix) {
+			log.Infoln("File", matchName, "matches suffix", suffix)
+			if err := processMatchedFile(params, dirName, fileInfo, "match suffix "+suffix, []string{matchName}, pruner); err != nil {
+				return err
 			}
 		}
 	}
 	for _, pattern := range patterns {
-		if pattern.MatchString(fileName) {
-			log.Infoln("File", fileName, "matches pattern", pattern)
-			if !params.dryRun {
-				if err := processFile(params.action, params.dirName, params.destDir, fileName); err != nil {
-					log.Errorf("Error processing file %v: %v", fileName, err.Error())
-				}
+		if groups := pattern.FindStringSubmatch(matchName); groups != nil {
+			log.Infoln("File", matchName, "matches pattern", pattern)
+			if err := processMatchedFile(params, dirName, fileInfo, "match pattern "+pattern.String(), groups, pruner); err != nil {
+				return err
 			}
 		}
 	}
 	return nil
 }
 
+// relativePath returns the path of the file relative to the base directory
+func relativePath(baseDir, filePath string) string {
+	relPath, err := filepath.Rel(baseDir, filePath)
+	if err != nil {
+		return path.Base(filePath)
+	}
+	return filepath.ToSlash(relPath)
+}
+
+// matchTemplateData describes the matching file to the destination and command templates
+func matchTemplateData(params matchCmdParamsType, filePath string, fileInfo os.FileInfo, groups []string) fileTemplateData {
+	fileTime, err := params.timeSource.fileTime(filePath, fileInfo)
+	if err != nil {
+		fileTime = fileInfo.ModTime()
+	}
+	return newFileTemplateData(params.dirName, path.Dir(filePath), path.Base(filePath), fileTime, groups)
+}
+
+// matchDestName returns the destination path of the file relative to the destination directory,
+// computed by the destination template or recreating the directory structure of the base directory
+func matchDestName(params matchCmdParamsType, filePath string, fileInfo os.FileInfo, groups []string) (string, error) {
+	if params.destTemplate == nil {
+		return path.Join(relativePath(params.dirName, path.Dir(filePath)), path.Base(filePath)), nil
+	}
+	return params.destTemplate.execute(matchTemplateData(params, filePath, fileInfo, groups))
+}
+
+// processMatchedFile adds the action to the plan and executes it unless in dry run.
+// Errors executing the action are only logged, so that the other files are processed.
+func processMatchedFile(params matchCmdParamsType, dirName string, fileInfo os.FileInfo, rule string, groups []string, pruner *dirPruner) error {
+	fileName := fileInfo.Name()
+	filePath := path.Join(dirName, fileName)
+	var destName string
+	var err error
+	switch {
+	case strings.ToUpper(params.action) == "RENAME":
+		destName, err = params.renameTemplate.execute(matchTemplateData(params, filePath, fileInfo, groups))
+	case len(params.destDir) > 0:
+		destName, err = matchDestName(params, filePath, fileInfo, groups)
+	}
+	if err != nil {
+		log.Errorf("Error processing file %v: %v", fileName, err.Error())
+		return nil
+	}
+	var commandLine []string
+	if strings.ToUpper(params.action) == "EXEC" {
+		if commandLine, err = renderCommand(params.command, params.commandTemplates, matchTemplateData(params, filePath, fileInfo, groups)); err != nil {
+			log.Errorf("Error processing file %v: %v", fileName, err.Error())
+			return nil
+		}
+	}
+	if err := params.plan.add(newMatchPlanEntry(params, filePath, fileInfo, rule, destName, commandLine)); err != nil {
+		return err
+	}
+
+	pruner.trackDir(dirName)
+	if !params.dryRun {
+		opts := processOptions{trashDir: params.trashDir, rule: rule, destName: destName, conflict: params.conflict, checksumOptions: params.checksumOptions, preserve: params.preserve}
+		opts.compression = params.compression
+		opts.maxExtractBytes = params.maxExtractBytes
+		opts.exec = execOptions{command: commandLine, timeout: time.Duration(params.timeout) * time.Second, onSuccess: params.onSuccess, onFailure: params.onFailure}
+		opts.renamed = params.renamed
+		opts.latestLink = matchLatestLink(params)
+		opts.permissions = params.permissions
+		if err := processFile(params.action, dirName, params.destDir, fileName, opts); err != nil {
+			if !errors.Is(err, errFileSkipped) {
+				log.Errorf("Error processing file %v: %v", fileName, err.Error())
+			}
+			return nil
+		}
+	}
+	if removesSource(params.action) {
+		pruner.markRemoved(filePath)
+	}
+	return nil
+}
+
+// matchLatestLink returns the path of the latest link inside the destination directory
+func matchLatestLink(params matchCmdParamsType) string {
+	if len(params.latestLink) == 0 {
+		return ""
+	}
+	return path.Join(params.destDir, params.latestLink)
+}
+
+func newMatchPlanEntry(params matchCmdParamsType, filePath string, fileInfo os.FileInfo, rule, destName string, commandLine []string) planEntry {
+	fileTime, err := params.timeSource.fileTime(filePath, fileInfo)
+	if err != nil {
+		fileTime = fileInfo.ModTime()
+	}
+	entry := planEntry{
+		Path:    filePath,
+		Root:    params.dirName,
+		Size:    fileInfo.Size(),
+		ModTime: fileInfo.ModTime(),
+		AgeDays: time.Now().Sub(fileTime).Hours() / 24,
+		Action:  strings.ToUpper(params.action),
+		Rule:    rule,
+	}
+	switch entry.Action {
+	case "LINK", "HARDLINK":
+		entry.Destination = path.Join(params.destDir, destName)
+		entry.Options = map[string]string{"conflict": strings.ToLower(params.conflict)}
+		if latestLink := matchLatestLink(params); len(latestLink) > 0 {
+			entry.Options["latestLink"] = latestLink
+		}
+	case "COPY", "COPY-DELETE", "MOVE":
+		entry.Destination = path.Join(params.destDir, destName)
+		entry.Options = map[string]string{"conflict": strings.ToLower(params.conflict)}
+		if len(params.verify) > 0 {
+			entry.Options["verify"] = strings.ToLower(params.verify)
+		}
+		if params.checksumFile {
+			entry.Options["checksumFile"] = "true"
+		}
+		if len(params.preserveNames) > 0 {
+			entry.Options["preserve"] = strings.ToLower(strings.Join(params.preserveNames, ","))
+		}
+	case "COMPRESS", "EXTRACT":
+		destDir := params.destDir
+		if len(destDir) == 0 {
+			destDir, destName = path.Dir(filePath), fileInfo.Name()
+		}
+		entry.Destination = path.Join(destDir, destName)
+		entry.Options = map[string]string{"conflict": strings.ToLower(params.conflict)}
+		if entry.Action == "COMPRESS" {
+			entry.Destination += compressedExt(params.compression)
+			entry.Options["compression"] = strings.ToLower(params.compression)
+		} else {
+			entry.Destination = path.Dir(entry.Destination)
+			entry.Options["maxExtractSize"] = params.maxExtractSize
+		}
+	case "RENAME":
+		entry.Destination = path.Join(path.Dir(filePath), destName)
+		entry.Options = map[string]string{"conflict": strings.ToLower(params.conflict)}
+	case "EXEC":
+		entry.Options = newExecPlanOptions(commandLine, params.timeout, params.onSuccess, params.onFailure)
+		entry.Options["conflict"] = strings.ToLower(params.conflict)
+		if strings.ToUpper(params.onSuccess.action) == "COMPRESS" || strings.ToUpper(params.onFailure.action) == "COMPRESS" {
+			entry.Options["compression"] = strings.ToLower(params.compression)
+		}
+		if len(params.trashDir) > 0 {
+			entry.Options["trashDir"] = params.trashDir
+		}
+	case "DELETE":
+		if len(params.trashDir) > 0 {
+			entry.Action = "TRASH"
+			entry.Destination = params.trashDir
+		}
+	}
+	entry.Options = newPermissionPlanOptions(entry.Options, params.mode, params.owner, params.group)
+	return entry
+}
+
 func checkMatchParameters(params matchCmdParamsType) error {
 	directory, err := os.Open(params.dirName)
 	if err != nil {
@@ -160,7 +440,7 @@ func checkMatchParameters(params matchCmdParamsType) error {
 	}
 
 	switch strings.ToUpper(params.action) {
-	case "COPY", "MOVE", "COPY-DELETE":
+	case "COPY", "MOVE", "COPY-DELETE", "LINK", "HARDLINK":
 		if len(params.destDir) == 0 {
 			log.Errorln("Missing destination directory")
 			return errors.New("missing destination directory")
@@ -174,6 +454,54 @@ func checkMatchParameters(params matchCmdParamsType) error {
 			log.Warnln("Error closing destination directory", err.Error())
 		}
 
+	case "COMPRESS", "EXTRACT":
+		if len(params.destDir) > 0 {
+			destDir, err := os.Open(params.destDir)
+			if err != nil {
+				log.Errorln("Invalid directory", err)
+				return err
+			}
+			if err := destDir.Close(); err != nil {
+				log.Warnln("Error closing destination directory", err.Error())
+			}
+		}
+		if err := checkCompression(params.compression); err != nil {
+			return err
+		}
+		if _, err := parseMaxExtractSize(params.maxExtractSize); err != nil {
+			return err
+		}
+
+	case "EXEC":
+		if err := checkExecOptions(params.command, params.commandArgs, params.timeout); err != nil {
+			return err
+		}
+		if err := checkFollowUpAction(params.onSuccess.action, params.onSuccess.destDir); err != nil {
+			return err
+		}
+		if err := checkFollowUpAction(params.onFailure.action, params.onFailure.destDir); err != nil {
+			return err
+		}
+		if err := checkCompression(params.compression); err != nil {
+			return err
+		}
+
+	case "RENAME":
+		if len(params.renameTemplateText) == 0 {
+			log.Errorln("Missing rename template")
+			return errors.New("missing rename template")
+		}
+		if _, err := newFileTemplate(params.renameTemplateText); err != nil {
+			log.Errorln("Invalid rename template", params.renameTemplateText, err.Error())
+			return err
+		}
+
+	case "PERMISSIONS":
+		if len(params.mode) == 0 && len(params.owner) == 0 && len(params.group) == 0 {
+			log.Errorln("At least one of mode, owner or group must be specified")
+			return errors.New("missing permissions")
+		}
+
 	case "DELETE":
 	default:
 		log.Errorln("Invalid action", params.action)
@@ -194,20 +522,158 @@ func checkMatchParameters(params matchCmdParamsType) error {
 		}
 	}
 
+	if _, err := newFileTemplate(params.destTemplateText); err != nil {
+		log.Errorln("Invalid destination template", params.destTemplateText, err.Error())
+		return err
+	}
+
+	if err := checkConflictPolicy(params.conflict); err != nil {
+		return err
+	}
+
+	if err := checkLatestLink(params.latestLink); err != nil {
+		return err
+	}
+
+	if err := checkPermissionsAction(params.action, params.mode, params.owner, params.group); err != nil {
+		return err
+	}
+	if _, err := newPermissionOptions(params.mode, params.owner, params.group); err != nil {
+		return err
+	}
+
+	if err := checkChecksumOptions(params.checksumOptions); err != nil {
+		return err
+	}
+
+	if _, err := newPreserveOptions(params.preserveNames); err != nil {
+		return err
+	}
+
+	if _, err := newTimeSource(params.timeSourceName, params.timePattern, params.timeLayout); err != nil {
+		return err
+	}
+
+	if err := checkPruneParameters(params.pruneMinAge, params.pruneProtect); err != nil {
+		return err
+	}
+
+	if err := checkTrashDir(params.trashDir, false); err != nil {
+		return err
+	}
+
+	if err := checkOutputFormat(params.output); err != nil {
+		return err
+	}
+	if params.trashRetentionDays < 0 {
+		log.Errorln("Invalid trash retention, zero or positive number expected")
+		return errors.New("invalid trash-retention")
+	}
+
+	if err := checkWalkOptions(params.walkOptions); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func processFile(action, sourceDir, destDir, fileName string) error {
+// processOptions holds the settings shared by the actions of match and watch rules
+type processOptions struct {
+	trashDir string
+	rule     string
+	// destName is the destination path relative to the destination directory, the file name if empty
+	destName string
+	// conflict is the policy applied when the destination file already exists
+	conflict string
+	checksumOptions
+	preserve preserveOptions
+	// compression is the format of the COMPRESS action (gzip, zstd)
+	compression string
+	// maxExtractBytes is the maximum total size of the files extracted from an archive
+	maxExtractBytes uint64
+	exec            execOptions
+	// renamed collects the new paths of the renamed files, when not nil
+	renamed map[string]bool
+	// latestLink is the path of the link to the most recent file of the LINK and HARDLINK actions
+	latestLink string
+	// permissions are set on the processed file before it is placed in the destination
+	permissions permissionOptions
+}
+
+// errFileSkipped is returned by processFile when the file is left untouched, as when the
+// conflict policy does not allow to write the destination file
+var errFileSkipped = errors.New("file skipped")
+
+func processFile(action, sourceDir, destDir, fileName string, opts processOptions) error {
+	for attempt := 1; ; attempt++ {
+		err := processFileOnce(action, sourceDir, destDir, fileName, opts)
+		if !errors.Is(err, errDestinationExists) || attempt == maxPlaceAttempts {
+			return err
+		}
+		log.Infof("Destination of file %v created while processing, applying the conflict policy again", fileName)
+	}
+}
+
+func processFileOnce(action, sourceDir, destDir, fileName string, opts processOptions) error {
+	if strings.ToUpper(action) == "PERMISSIONS" {
+		return setFilePermissions(path.Join(sourceDir, fileName), opts.permissions)
+	}
+
+	switch strings.ToUpper(action) {
+	case "COMPRESS", "EXTRACT":
+		// Without destination directory files are compressed and extracted in place
+		if len(destDir) == 0 {
+			destDir = sourceDir
+		}
+	case "RENAME":
+		destDir = sourceDir
+		if len(opts.destName) == 0 || strings.Contains(opts.destName, "/") {
+			return fmt.Errorf("invalid new name %q of file %v", opts.destName, fileName)
+		}
+		if opts.destName == fileName {
+			log.Infof("Skipping file %v, name unchanged", fileName)
+			return errFileSkipped
+		}
+	}
+	destPath := path.Join(destDir, fileName)
+	if len(opts.destName) > 0 {
+		destPath = path.Join(destDir, opts.destName)
+	}
+
+	switch strings.ToUpper(action) {
+	case "COMPRESS":
+		if strings.HasSuffix(fileName, compressedExt(opts.compression)) {
+			log.Infof("Skipping file %v, already compressed", fileName)
+			return errFileSkipped
+		}
+		destPath += compressedExt(opts.compression)
+		fallthrough
+	case "COPY", "COPY-DELETE", "MOVE", "RENAME", "LINK", "HARDLINK":
+		if err := createDestDir(destDir, destPath); err != nil {
+			log.Errorf("Error creating directory %v: %v", path.Dir(destPath), err.Error())
+			return err
+		}
+		resolvedPath, ok, err := resolveConflict(opts.conflict, path.Join(sourceDir, fileName), destPath)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errFileSkipped
+		}
+		destPath = resolvedPath
+	}
+	replace := replacesDestination(opts.conflict)
+
 	switch strings.ToUpper(action) {
 	case "COPY":
-		log.Infof("Copying file %v to directory %v", fileName, destDir)
-		if err := copyFile(path.Join(sourceDir, fileName), path.Join(destDir, fileName)); err != nil {
+		log.Infof("Copying file %v to directory %v", fileName, path.Dir(destPath))
+		if err := copyFileChecked(path.Join(sourceDir, fileName), destPath, opts.checksumOptions, opts.preserve, opts.permissions, replace); err != nil {
 			log.Errorf("Error copying file %v: %v", fileName, err.Error())
 			return err
 		}
 	case "COPY-DELETE":
-		log.Infof("Copying file %v to directory %v", fileName, destDir)
-		if err := copyFile(path.Join(sourceDir, fileName), path.Join(destDir, fileName)); err != nil {
+		log.Infof("Copying file %v to directory %v", fileName, path.Dir(destPath))
+		if err := copyFileChecked(path.Join(sourceDir, fileName), destPath, opts.checksumOptions, opts.preserve, opts.permissions, replace); err != nil {
 			log.Errorf("Error copying file %v: %v", fileName, err.Error())
 			return err
 		}
@@ -217,12 +683,68 @@ func processFile(action, sourceDir, destDir, fileName string) error {
 			return err
 		}
 	case "MOVE":
-		log.Infof("Moving file %v to directory %v", fileName, destDir)
-		if err := moveFile(path.Join(sourceDir, fileName), path.Join(destDir, fileName)); err != nil {
+		// The permissions are set before the move, so that the file lands in the destination with the right access
+		if err := setFilePermissions(path.Join(sourceDir, fileName), opts.permissions); err != nil {
+			return err
+		}
+		log.Infof("Moving file %v to directory %v", fileName, path.Dir(destPath))
+		if err := moveFileChecked(path.Join(sourceDir, fileName), destPath, opts.checksumOptions, replace); err != nil {
 			log.Errorf("Error moving file %v: %v", fileName, err.Error())
 			return err
 		}
+	case "COMPRESS":
+		log.Infof("Compressing file %v to %v", fileName, destPath)
+		if err := compressFile(path.Join(sourceDir, fileName), destPath, opts.compression, opts.preserve, opts.permissions, replace); err != nil {
+			log.Errorf("Error compressing file %v: %v", fileName, err.Error())
+			return err
+		}
+		log.Infof("Deleting file %v", fileName)
+		if err := deleteFile(path.Join(sourceDir, fileName)); err != nil {
+			log.Errorf("Error deleting file %v: %v", fileName, err.Error())
+			return err
+		}
+	case "LINK", "HARDLINK":
+		hard := strings.ToUpper(action) == "HARDLINK"
+		log.Infof("Linking file %v to %v", fileName, destPath)
+		if err := linkFile(path.Join(sourceDir, fileName), destPath, hard, replace); err != nil {
+			log.Errorf("Error linking file %v: %v", fileName, err.Error())
+			return err
+		}
+		if len(opts.latestLink) > 0 {
+			if err := updateLatestLink(opts.latestLink, path.Join(sourceDir, fileName), hard); err != nil {
+				log.Errorf("Error updating latest link to file %v: %v", fileName, err.Error())
+				return err
+			}
+		}
+	case "RENAME":
+		if err := setFilePermissions(path.Join(sourceDir, fileName), opts.permissions); err != nil {
+			return err
+		}
+		log.Infof("Renaming file %v to %v", fileName, path.Base(destPath))
+		if err := placeFile(path.Join(sourceDir, fileName), destPath, replace); err != nil {
+			log.Errorf("Error renaming file %v: %v", fileName, err.Error())
+			return err
+		}
+		if opts.renamed != nil {
+			opts.renamed[destPath] = true
+		}
+	case "EXEC":
+		return execFile(sourceDir, fileName, opts)
+	case "EXTRACT":
+		log.Infof("Extracting file %v to directory %v", fileName, path.Dir(destPath))
+		if err := extractArchive(path.Join(sourceDir, fileName), path.Dir(destPath), opts.conflict, opts.maxExtractBytes); err != nil {
+			log.Errorf("Error extracting file %v: %v", fileName, err.Error())
+			return err
+		}
 	case "DELETE":
+		if len(opts.trashDir) > 0 {
+			log.Infof("Trashing file %v", fileName)
+			if err := trashFile(opts.trashDir, path.Join(sourceDir, fileName), opts.rule); err != nil {
+				log.Errorf("Error trashing file %v: %v", fileName, err.Error())
+				return err
+			}
+			return nil
+		}
 		log.Infof("Deleting file %v", fileName)
 		if err := deleteFile(path.Join(sourceDir, fileName)); err != nil {
 			log.Errorf("Error deleting file %v: %v", fileName, err.Error())
@@ -232,38 +754,44 @@ func processFile(action, sourceDir, destDir, fileName string) error {
 	return nil
 }
 
-func copyFile(fromFile string, toFile string) error {
-	from, err := os.Open(fromFile)
-	if err != nil {
-		return err
+// createDestDir creates the missing subdirectories of the destination directory leading to destPath
+func createDestDir(destDir, destPath string) error {
+	if path.Dir(destPath) == path.Clean(destDir) {
+		return nil
 	}
-	defer func(from *os.File) {
-		err := from.Close()
-		if err != nil {
-			log.Warnln("Error closing source file", fromFile, err.Error())
-		}
-	}(from)
+	return os.MkdirAll(path.Dir(destPath), 0755)
+}
 
-	to, err := os.Create(toFile)
-	if err != nil {
-		return err
+// removesSource reports whether the action removes the source file
+func removesSource(action string) bool {
+	switch strings.ToUpper(action) {
+	case "COPY-DELETE", "MOVE", "DELETE", "COMPRESS":
+		return true
 	}
-	defer func(to *os.File) {
-		err := to.Close()
-		if err != nil {
-			log.Warnln("Error closing destination file", toFile, err.Error())
-		}
-	}(to)
+	return false
+}
 
-	_, err = io.Copy(to, from)
-	if err != nil {
-		return err
-	}
-	return nil
+func copyFile(fromFile string, toFile string) error {
+	_, err := copyFileAtomic(fromFile, toFile, true, "", nil)
+	return err
 }
 
+// moveFile renames the file, falling back to copy and delete when the destination
+// is on another filesystem
 func moveFile(fromFile string, toFile string) error {
-	return os.Rename(fromFile, toFile)
+	return moveFileChecked(fromFile, toFile, checksumOptions{}, true)
+}
+
+// moveFileChecked is moveFile verifying the copy with the given checksum options
+// when the destination is on another filesystem. Unless replace is set an existing
+// destination file is never replaced.
+func moveFileChecked(fromFile string, toFile string, opts checksumOptions, replace bool) error {
+	err := placeFile(fromFile, toFile, replace)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	log.Infof("Destination %v on another filesystem, copying file %v", toFile, fromFile)
+	return moveFileAcrossFilesystems(fromFile, toFile, opts.algorithm(), replace)
 }
 
 func deleteFile(fileName string) error {
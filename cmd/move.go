@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+)
+
+// moveFileAcrossFilesystems copies the file, syncing it to disk, and deletes the source
+// only after verifying the content of the copy with the given checksum algorithm.
+// All the metadata of the file is preserved. Unless replace is set an existing destination
+// file is never replaced.
+func moveFileAcrossFilesystems(fromFile string, toFile string, algorithm string, replace bool) error {
+	fileInfo, err := os.Stat(fromFile)
+	if err != nil {
+		return err
+	}
+	_, err = copyFileAtomic(fromFile, toFile, replace, algorithm, func(tempFile string, sum []byte) error {
+		if err := verifyFileCopy(tempFile, fileInfo.Size(), algorithm, sum); err != nil {
+			return err
+		}
+		return preserveMetadata(fromFile, tempFile, fileInfo, preserveAll)
+	})
+	if err != nil {
+		return err
+	}
+	return os.Remove(fromFile)
+}
+
+// verifyFileCopy reads back the copy, checking its size and checksum
+func verifyFileCopy(toFile string, size int64, algorithm string, sum []byte) error {
+	to, err := os.Open(toFile)
+	if err != nil {
+		return err
+	}
+	defer func(to *os.File) {
+		err := to.Close()
+		if err != nil {
+			log.Warnln("Error closing destination file", toFile, err.Error())
+		}
+	}(to)
+
+	hash := newChecksumHash(algorithm)
+	copied, err := io.Copy(hash, to)
+	if err != nil {
+		return err
+	}
+	if copied != size || !bytes.Equal(hash.Sum(nil), sum) {
+		return fmt.Errorf("verification of the copy %v failed", toFile)
+	}
+	return nil
+}
+
+// preserveFileTimes sets the access and modification times of the copy to the ones of the source file
+func preserveFileTimes(fromFile string, toFile string, fileInfo os.FileInfo) error {
+	accessTime, err := statFileTime("atime", fromFile, fileInfo)
+	if err != nil {
+		accessTime = fileInfo.ModTime()
+	}
+	return os.Chtimes(toFile, accessTime, fileInfo.ModTime())
+}
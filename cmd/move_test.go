@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMoveFileAcrossFilesystems(t *testing.T) {
+	modTime := time.Date(2024, time.March, 5, 14, 30, 0, 0, time.Local)
+	tests := []struct {
+		name       string
+		algorithm  string
+		replace    bool
+		destExists bool
+		want       string
+		wantErr    error
+	}{
+		{name: "new file", algorithm: "sha256", want: "source"},
+		{name: "default algorithm", want: "source"},
+		{name: "existing file replaced", algorithm: "xxhash", replace: true, destExists: true, want: "source"},
+		{name: "existing file kept", algorithm: "sha256", destExists: true, want: "dest", wantErr: errDestinationExists},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			fromFile, toFile := path.Join(dir, "from"), path.Join(dir, "to")
+			writeTestFile(t, fromFile, "source", modTime)
+			if err := os.Chmod(fromFile, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if test.destExists {
+				writeTestFile(t, toFile, "dest", time.Now())
+			}
+			err := moveFileAcrossFilesystems(fromFile, toFile, test.algorithm, test.replace)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("moveFileAcrossFilesystems() error = %v, want %v", err, test.wantErr)
+			}
+			if content, err := os.ReadFile(toFile); err != nil || string(content) != test.want {
+				t.Errorf("destination content = %q, %v, want %q", content, err, test.want)
+			}
+			_, err = os.Stat(fromFile)
+			if test.wantErr != nil {
+				if err != nil {
+					t.Errorf("source file removed although not moved: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("source file not removed: %v", err)
+			}
+			// The metadata of the source is kept
+			destInfo, err := os.Stat(toFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !destInfo.ModTime().Equal(modTime) || destInfo.Mode().Perm() != 0600 {
+				t.Errorf("destination modified %v with mode %v, want %v and %v", destInfo.ModTime(), destInfo.Mode().Perm(), modTime, os.FileMode(0600))
+			}
+		})
+	}
+}
+
+func TestMoveFileCheckedAcrossFilesystems(t *testing.T) {
+	// /dev/shm is usually a memory file system, distinct from the one of the temporary directory
+	otherDir, err := os.MkdirTemp("/dev/shm", "dirkeeper-test")
+	if err != nil {
+		t.Skip("no other file system available:", err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(otherDir)
+	})
+	dir := t.TempDir()
+	fromFile, toFile := path.Join(dir, "from"), path.Join(otherDir, "to")
+	writeTestFile(t, fromFile, "source", time.Now())
+	if err := os.Link(fromFile, path.Join(otherDir, "link")); !errors.Is(err, syscall.EXDEV) {
+		t.Skip("temporary directory and /dev/shm on the same file system")
+	}
+
+	if err := moveFileChecked(fromFile, toFile, checksumOptions{}, false); err != nil {
+		t.Fatalf("moveFileChecked() error: %v", err)
+	}
+	if content, err := os.ReadFile(toFile); err != nil || string(content) != "source" {
+		t.Errorf("destination content = %q, %v", content, err)
+	}
+	if _, err := os.Stat(fromFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("source file not removed: %v", err)
+	}
+}
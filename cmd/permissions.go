@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// permissionOptions normalizes the mode and the ownership of the processed files
+type permissionOptions struct {
+	// mode is the permission mode to set, nil to keep the current one
+	mode *os.FileMode
+	// uid and gid are the owner and the group to set, nil to keep the current ones
+	uid *int
+	gid *int
+}
+
+// newPermissionOptions parses the octal mode and the owner and group, given by name or numeric id.
+// Empty values keep the current settings of the files.
+func newPermissionOptions(mode, owner, group string) (permissionOptions, error) {
+	var opts permissionOptions
+	if len(mode) > 0 {
+		bits, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || bits > 07777 {
+			log.Errorln("Invalid mode, octal permissions expected", mode)
+			return opts, errors.New("invalid mode")
+		}
+		fileMode := os.FileMode(bits & 0777)
+		if bits&04000 != 0 {
+			fileMode |= os.ModeSetuid
+		}
+		if bits&02000 != 0 {
+			fileMode |= os.ModeSetgid
+		}
+		if bits&01000 != 0 {
+			fileMode |= os.ModeSticky
+		}
+		opts.mode = &fileMode
+	}
+	if len(owner) > 0 {
+		uid, err := lookupId(owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			log.Errorln("Invalid owner", owner, err.Error())
+			return opts, err
+		}
+		opts.uid = &uid
+	}
+	if len(group) > 0 {
+		gid, err := lookupId(group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			log.Errorln("Invalid group", group, err.Error())
+			return opts, err
+		}
+		opts.gid = &gid
+	}
+	return opts, nil
+}
+
+// lookupId returns the numeric id, looking up the name when it is not a number
+func lookupId(name string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil && id >= 0 {
+		return id, nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(id)
+}
+
+// checkPermissionsAction verifies that the permissions are only configured for the actions setting them:
+// the ones leaving a file of their own in its final place, and the permissions action itself
+func checkPermissionsAction(action, mode, owner, group string) error {
+	if len(mode) == 0 && len(owner) == 0 && len(group) == 0 {
+		return nil
+	}
+	switch strings.ToUpper(action) {
+	case "COPY", "COPY-DELETE", "MOVE", "COMPRESS", "RENAME", "PERMISSIONS":
+		return nil
+	}
+	log.Errorln("Permissions not supported by action", action)
+	return errors.New("permissions not supported by action")
+}
+
+func (opts permissionOptions) any() bool {
+	return opts.mode != nil || opts.uid != nil || opts.gid != nil
+}
+
+// setPermissions changes the ownership and then the mode of the file, since changing
+// the owner clears the setuid and setgid bits
+func setPermissions(filePath string, opts permissionOptions) error {
+	if opts.uid != nil || opts.gid != nil {
+		uid, gid := -1, -1
+		if opts.uid != nil {
+			uid = *opts.uid
+		}
+		if opts.gid != nil {
+			gid = *opts.gid
+		}
+		if err := os.Chown(filePath, uid, gid); err != nil {
+			return err
+		}
+	}
+	if opts.mode != nil {
+		return os.Chmod(filePath, *opts.mode)
+	}
+	return nil
+}
+
+// setFilePermissions sets the permissions, if any, of the processed file
+func setFilePermissions(filePath string, opts permissionOptions) error {
+	if !opts.any() {
+		return nil
+	}
+	log.Infof("Setting permissions of file %v", filePath)
+	if err := setPermissions(filePath, opts); err != nil {
+		log.Errorf("Error setting permissions of file %v: %v", filePath, err.Error())
+		return err
+	}
+	return nil
+}
+
+// setCopyPermissions sets the permissions, if any, on the temporary copy of toFile before its rename,
+// so that the file never appears in the destination with other permissions
+func setCopyPermissions(tempFile, toFile string, opts permissionOptions) error {
+	if !opts.any() {
+		return nil
+	}
+	log.Infof("Setting permissions of file %v", toFile)
+	return setPermissions(tempFile, opts)
+}
+
+// newPermissionPlanOptions records the permissions in the plan options, as configured
+func newPermissionPlanOptions(options map[string]string, mode, owner, group string) map[string]string {
+	if len(mode) == 0 && len(owner) == 0 && len(group) == 0 {
+		return options
+	}
+	if options == nil {
+		options = map[string]string{}
+	}
+	for key, value := range map[string]string{"mode": mode, "owner": owner, "group": group} {
+		if len(value) > 0 {
+			options[key] = value
+		}
+	}
+	return options
+}
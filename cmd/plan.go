@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// planEntry describes an action on a file, as planned by a dry run or executed by a real run
+type planEntry struct {
+	Path        string    `json:"path"`
+	Root        string    `json:"root,omitempty"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mtime"`
+	AgeDays     float64   `json:"ageDays"`
+	Action      string    `json:"action"`
+	Destination string    `json:"destination,omitempty"`
+	Rule        string    `json:"rule,omitempty"`
+	// Options holds the settings needed to execute the action exactly as planned
+	Options map[string]string `json:"options,omitempty"`
+}
+
+type planTotals struct {
+	Files int   `json:"files"`
+	Size  int64 `json:"size"`
+}
+
+// planHeader describes the run that generated a plan
+type planHeader struct {
+	Command     string    `json:"command"`
+	GeneratedAt time.Time `json:"generatedAt"`
+	DryRun      bool      `json:"dryRun"`
+}
+
+// planDocument is the JSON representation of a whole plan
+type planDocument struct {
+	planHeader
+	Entries []planEntry `json:"entries"`
+	Totals  planTotals  `json:"totals"`
+}
+
+var planCSVHeader = []string{"path", "root", "size", "mtime", "ageDays", "action", "destination", "rule", "options"}
+
+// planWriter streams the plan entries in JSON or CSV format, so that plans of
+// huge directories are never kept in memory. A nil writer does nothing.
+type planWriter struct {
+	format    string
+	out       io.Writer
+	csvWriter *csv.Writer
+	totals    planTotals
+	mutex     sync.Mutex
+}
+
+// newPlanWriter returns a writer for the json and csv formats, or nil for the text format
+func newPlanWriter(format, command string, dryRun bool, out io.Writer) (*planWriter, error) {
+	w := &planWriter{format: strings.ToLower(format), out: out}
+	switch w.format {
+	case "", "text":
+		return nil, nil
+	case "json":
+		header, err := json.Marshal(planHeader{Command: command, GeneratedAt: time.Now(), DryRun: dryRun})
+		if err != nil {
+			return nil, err
+		}
+		// The header object is left open, the entries are written one at a time and then the totals
+		if _, err := fmt.Fprintf(out, `%s,"entries":[`, strings.TrimSuffix(string(header), "}")); err != nil {
+			return nil, err
+		}
+	case "csv":
+		w.csvWriter = csv.NewWriter(out)
+		if err := w.csvWriter.Write(planCSVHeader); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid output format %v", format)
+	}
+	return w, nil
+}
+
+func (w *planWriter) add(entry planEntry) error {
+	if w == nil {
+		return nil
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	entry.AgeDays = math.Round(entry.AgeDays*100) / 100
+	entry.Path = absPath(entry.Path)
+	entry.Root = absPath(entry.Root)
+	entry.Destination = absPath(entry.Destination)
+
+	switch w.format {
+	case "json":
+		content, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		separator := ","
+		if w.totals.Files == 0 {
+			separator = ""
+		}
+		if _, err := fmt.Fprintf(w.out, "%v\n  %s", separator, content); err != nil {
+			return err
+		}
+	case "csv":
+		err := w.csvWriter.Write([]string{
+			entry.Path,
+			entry.Root,
+			strconv.FormatInt(entry.Size, 10),
+			entry.ModTime.Format(time.RFC3339Nano),
+			strconv.FormatFloat(entry.AgeDays, 'f', 2, 64),
+			entry.Action,
+			entry.Destination,
+			entry.Rule,
+			formatPlanOptions(entry.Options),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	w.totals.Files++
+	w.totals.Size += entry.Size
+	return nil
+}
+
+// close writes the totals and completes the plan
+func (w *planWriter) close() error {
+	if w == nil {
+		return nil
+	}
+	switch w.format {
+	case "json":
+		totals, err := json.Marshal(w.totals)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w.out, "\n],\"totals\":%s}\n", totals)
+		return err
+	case "csv":
+		err := w.csvWriter.Write([]string{"TOTAL", "", strconv.FormatInt(w.totals.Size, 10), "", "", "", "", fmt.Sprintf("%d files", w.totals.Files), ""})
+		if err != nil {
+			return err
+		}
+		w.csvWriter.Flush()
+		return w.csvWriter.Error()
+	}
+	return nil
+}
+
+func formatPlanOptions(options map[string]string) string {
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = key + "=" + options[key]
+	}
+	return strings.Join(values, ";")
+}
+
+// readPlan loads a plan previously written in JSON format
+func readPlan(planFile string) (planDocument, error) {
+	var plan planDocument
+	content, err := os.ReadFile(planFile)
+	if err != nil {
+		return plan, err
+	}
+	err = json.Unmarshal(content, &plan)
+	return plan, err
+}
+
+// absPath makes the plan independent of the working directory
+func absPath(filePath string) string {
+	if len(filePath) == 0 {
+		return filePath
+	}
+	if absFilePath, err := filepath.Abs(filePath); err == nil {
+		return absFilePath
+	}
+	return filePath
+}
+
+func checkOutputFormat(format string) error {
+	switch strings.ToLower(format) {
+	case "", "text", "json", "csv":
+		return nil
+	}
+	log.Errorln("Invalid output format", format)
+	return errors.New("invalid output format")
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPlanWriterJSON(t *testing.T) {
+	modTime := time.Date(2024, time.March, 5, 14, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		dryRun  bool
+		entries []planEntry
+	}{
+		{name: "empty plan", dryRun: true},
+		{name: "one entry", dryRun: true, entries: []planEntry{
+			{Path: "/data/a.log", Size: 10, ModTime: modTime, AgeDays: 3.5, Action: "DELETE", Rule: "cleanold max-age"},
+		}},
+		{name: "entries with options", entries: []planEntry{
+			{Path: "/data/a.log", Size: 10, ModTime: modTime, Action: "MOVE", Destination: "/dest/a.log", Options: map[string]string{"conflict": "fail"}},
+			{Path: "/data/b.log", Root: "/data", Size: 20, ModTime: modTime, Action: "TRASH", Destination: "/trash"},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			w, err := newPlanWriter("json", "match", test.dryRun, &out)
+			if err != nil {
+				t.Fatalf("newPlanWriter() error: %v", err)
+			}
+			var size int64
+			for _, entry := range test.entries {
+				if err := w.add(entry); err != nil {
+					t.Fatalf("add() error: %v", err)
+				}
+				size += entry.Size
+			}
+			if err := w.close(); err != nil {
+				t.Fatalf("close() error: %v", err)
+			}
+
+			var plan planDocument
+			if err := json.Unmarshal(out.Bytes(), &plan); err != nil {
+				t.Fatalf("invalid plan %s: %v", out.Bytes(), err)
+			}
+			if plan.Command != "match" || plan.DryRun != test.dryRun || plan.GeneratedAt.IsZero() {
+				t.Errorf("plan header = %+v", plan.planHeader)
+			}
+			if len(plan.Entries) != len(test.entries) || (len(test.entries) > 0 && !reflect.DeepEqual(plan.Entries, test.entries)) {
+				t.Errorf("plan entries = %+v, want %+v", plan.Entries, test.entries)
+			}
+			if want := (planTotals{Files: len(test.entries), Size: size}); plan.Totals != want {
+				t.Errorf("plan totals = %+v, want %+v", plan.Totals, want)
+			}
+		})
+	}
+}
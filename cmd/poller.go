@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path"
+	"time"
+)
+
+// watchEvent is a new entry found in a watched directory
+type watchEvent struct {
+	os.FileInfo
+	// Path is the path of the entry inside the watched directory
+	Path string
+}
+
+// dirPoller detects the new entries of the watched directories, listing them at every poll.
+// The directories are read in batches and only the names of their entries are kept between
+// polls, so huge directories are scanned without loading all their metadata in memory.
+type dirPoller struct {
+	opts scanOptions
+	dirs []string
+	// seen holds the names of the entries of each directory found by the previous scan
+	seen map[string]map[string]bool
+}
+
+func newDirPoller(opts scanOptions) *dirPoller {
+	return &dirPoller{opts: opts, seen: map[string]map[string]bool{}}
+}
+
+// add scans the directory for the first time, logging the progress, and returns the number
+// of entries found. The entries already in the directory are not reported as new.
+func (p *dirPoller) add(dirName string) (int, error) {
+	seen, err := p.scan(dirName, newScanProgress(dirName, p.opts), nil)
+	if err != nil {
+		return 0, err
+	}
+	p.dirs = append(p.dirs, dirName)
+	p.seen[dirName] = seen
+	return len(seen), nil
+}
+
+// poll scans the watched directories, calling fn on the entries not found by the previous scan
+func (p *dirPoller) poll(fn func(event watchEvent)) {
+	for _, dirName := range p.dirs {
+		seen, err := p.scan(dirName, newScanProgress(dirName, scanOptions{}), fn)
+		if err != nil {
+			log.Errorln("Error scanning directory", dirName, err.Error())
+			// The entries missing from a partial scan are kept, so they are not reported again
+			for name := range p.seen[dirName] {
+				seen[name] = true
+			}
+		}
+		p.seen[dirName] = seen
+	}
+}
+
+func (p *dirPoller) scan(dirName string, progress *scanProgress, fn func(event watchEvent)) (map[string]bool, error) {
+	previous := p.seen[dirName]
+	seen := map[string]bool{}
+	err := readDirectory(dirName, p.opts.batchSize, func(fileInfo os.FileInfo) error {
+		progress.add()
+		seen[fileInfo.Name()] = true
+		if fn != nil && !previous[fileInfo.Name()] {
+			fn(watchEvent{FileInfo: fileInfo, Path: path.Join(dirName, fileInfo.Name())})
+		}
+		return nil
+	})
+	return seen, err
+}
+
+// run polls the watched directories at every interval, until stop is closed
+func (p *dirPoller) run(interval time.Duration, stop <-chan struct{}, fn func(event watchEvent)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.poll(fn)
+		case <-stop:
+			return
+		}
+	}
+}
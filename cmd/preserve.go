@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strings"
+)
+
+// preserveOptions selects the metadata of the source file kept by the copies
+type preserveOptions struct {
+	mode       bool
+	timestamps bool
+	ownership  bool
+	xattrs     bool
+}
+
+// preserveAll keeps all the metadata, as needed when moving files
+var preserveAll = preserveOptions{mode: true, timestamps: true, ownership: true, xattrs: true}
+
+func newPreserveOptions(values []string) (preserveOptions, error) {
+	var opts preserveOptions
+	for _, value := range values {
+		switch strings.ToLower(value) {
+		case "mode":
+			opts.mode = true
+		case "timestamps":
+			opts.timestamps = true
+		case "ownership":
+			opts.ownership = true
+		case "xattrs":
+			opts.xattrs = true
+		case "all":
+			opts = preserveAll
+		default:
+			log.Errorln("Invalid metadata to preserve", value)
+			return opts, errors.New("invalid preserve option")
+		}
+	}
+	return opts, nil
+}
+
+func (opts preserveOptions) any() bool {
+	return opts.mode || opts.timestamps || opts.ownership || opts.xattrs
+}
+
+// preserveMetadata copies the selected metadata of the source file to the copy. Ownership is
+// only preserved when running as root, and errors preserving ownership and extended attributes
+// are only logged, since the destination filesystem may not support them.
+func preserveMetadata(fromFile string, toFile string, fileInfo os.FileInfo, opts preserveOptions) error {
+	if opts.xattrs {
+		if err := preserveXattrs(fromFile, toFile); err != nil {
+			log.Warnln("Error preserving extended attributes of file", fromFile, err.Error())
+		}
+	}
+	if opts.ownership {
+		if os.Geteuid() != 0 {
+			log.Debugln("Ownership of file", fromFile, "not preserved, root privileges required")
+		} else if err := preserveOwnership(toFile, fileInfo); err != nil {
+			log.Warnln("Error preserving ownership of file", fromFile, err.Error())
+		}
+	}
+	// The mode is set after the ownership, since changing the owner clears the setuid and setgid bits
+	if opts.mode {
+		if err := os.Chmod(toFile, fileInfo.Mode().Perm()|fileInfo.Mode()&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+			return err
+		}
+	}
+	if opts.timestamps {
+		return preserveFileTimes(fromFile, toFile, fileInfo)
+	}
+	return nil
+}
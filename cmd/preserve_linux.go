@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"strings"
+	"syscall"
+)
+
+func preserveOwnership(toFile string, fileInfo os.FileInfo) error {
+	stat, ok := fileInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errors.New("file status not available")
+	}
+	return os.Lchown(toFile, int(stat.Uid), int(stat.Gid))
+}
+
+func preserveXattrs(fromFile string, toFile string) error {
+	size, err := unix.Llistxattr(fromFile, nil)
+	if err != nil || size == 0 {
+		return err
+	}
+	names := make([]byte, size)
+	if size, err = unix.Llistxattr(fromFile, names); err != nil {
+		return err
+	}
+
+	for _, name := range strings.Split(string(names[:size]), "\x00") {
+		if len(name) == 0 {
+			continue
+		}
+		valueSize, err := unix.Lgetxattr(fromFile, name, nil)
+		if err != nil {
+			return fmt.Errorf("attribute %v: %w", name, err)
+		}
+		value := make([]byte, valueSize)
+		if valueSize, err = unix.Lgetxattr(fromFile, name, value); err != nil {
+			return fmt.Errorf("attribute %v: %w", name, err)
+		}
+		if err := unix.Lsetxattr(toFile, name, value[:valueSize], 0); err != nil {
+			return fmt.Errorf("attribute %v: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package cmd
+
+import (
+	"errors"
+	"os"
+)
+
+func preserveOwnership(toFile string, fileInfo os.FileInfo) error {
+	return errors.New("ownership not supported on this platform")
+}
+
+func preserveXattrs(fromFile string, toFile string) error {
+	return errors.New("extended attributes not supported on this platform")
+}
@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dirPruner keeps track of the directories touched by a run, so that the ones
+// left empty can be removed at the end. A nil pruner does nothing.
+type dirPruner struct {
+	rootDir   string
+	minAge    time.Duration
+	protected map[string]bool
+	dryRun    bool
+	dirTimes  map[string]time.Time
+	removed   map[string]bool
+	mutex     sync.Mutex
+}
+
+func newDirPruner(rootDir string, minAge time.Duration, protected []string, dryRun bool) *dirPruner {
+	p := &dirPruner{
+		rootDir:   absPath(path.Clean(rootDir)),
+		minAge:    minAge,
+		protected: map[string]bool{},
+		dryRun:    dryRun,
+		dirTimes:  map[string]time.Time{},
+		removed:   map[string]bool{},
+	}
+	// The root and the protected directories are compared by absolute path, since
+	// the walked paths are relative when the root directory is
+	for _, dir := range append([]string{rootDir}, protected...) {
+		p.protected[absPath(dir)] = true
+	}
+	return p
+}
+
+// trackDir records the modification time of a directory before any file
+// inside it is removed, since removing files updates it
+func (p *dirPruner) trackDir(dirName string) {
+	if p == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.trackDirLocked(path.Clean(dirName))
+}
+
+func (p *dirPruner) trackDirLocked(dirName string) {
+	if _, ok := p.dirTimes[dirName]; ok {
+		return
+	}
+	dirInfo, err := os.Stat(dirName)
+	if err != nil {
+		log.Warnln("Error reading directory info", dirName, err.Error())
+		return
+	}
+	p.dirTimes[dirName] = dirInfo.ModTime()
+}
+
+// markRemoved records a file removed (or that would be removed in dry run) by the run
+func (p *dirPruner) markRemoved(filePath string) {
+	if p == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.trackDirLocked(path.Dir(filePath))
+	p.removed[path.Clean(filePath)] = true
+}
+
+// prune removes, bottom-up, the tracked directories left empty by the run
+func (p *dirPruner) prune() error {
+	if p == nil {
+		return nil
+	}
+
+	pending := make([]string, 0, len(p.dirTimes))
+	for dirName := range p.dirTimes {
+		pending = append(pending, dirName)
+	}
+	done := map[string]bool{}
+
+	for len(pending) > 0 {
+		sort.Slice(pending, func(i, j int) bool {
+			return strings.Count(pending[i], "/") > strings.Count(pending[j], "/")
+		})
+		dirName := pending[0]
+		pending = pending[1:]
+		done[dirName] = true
+
+		dirPath := absPath(dirName)
+		if p.protected[dirPath] || !strings.HasPrefix(dirPath, strings.TrimSuffix(p.rootDir, "/")+"/") {
+			continue
+		}
+
+		empty, err := p.isEmpty(dirName)
+		if err != nil {
+			log.Warnln("Error reading directory", dirName, err.Error())
+			continue
+		}
+		if !empty {
+			continue
+		}
+
+		dirInfo, err := os.Stat(dirName)
+		if err != nil {
+			log.Warnln("Error reading directory info", dirName, err.Error())
+			continue
+		}
+		dirModTime := p.dirTimes[dirName]
+		dirAge := time.Now().Sub(dirModTime)
+		if dirAge < p.minAge {
+			log.Debugln("Keeping empty directory", dirName, "not old enough")
+			continue
+		}
+
+		parentDir := path.Dir(dirName)
+		p.trackDir(parentDir)
+
+		if p.dryRun {
+			log.Infof("Candidate directory %-30v\t%10d bytes\t%v\t%.0f days old", dirName, dirInfo.Size(), dirModTime.Format(time.RFC3339), dirAge.Hours()/24)
+		} else {
+			log.Infof("Deleting directory %-30v\t%10d bytes\t%v\t%.0f days old", dirName, dirInfo.Size(), dirModTime.Format(time.RFC3339), dirAge.Hours()/24)
+			if err := os.Remove(dirName); err != nil {
+				log.Errorln("Impossible to delete directory", dirName)
+				return err
+			}
+		}
+		p.removed[dirName] = true
+
+		if !done[parentDir] && !contains(pending, parentDir) {
+			pending = append(pending, parentDir)
+		}
+	}
+	return nil
+}
+
+func (p *dirPruner) isEmpty(dirName string) (bool, error) {
+	empty := true
+	err := readDirectory(dirName, defaultBatchSize, func(fileInfo os.FileInfo) error {
+		// In dry run nothing is really removed, so the entries are checked against the removal list
+		if p.dryRun && p.removed[path.Join(dirName, fileInfo.Name())] {
+			return nil
+		}
+		empty = false
+		return errStopReading
+	})
+	if err != nil && !errors.Is(err, errStopReading) {
+		return false, err
+	}
+	return empty, nil
+}
+
+func checkPruneParameters(minAge int, protected []string) error {
+	if minAge < 0 {
+		log.Errorln("Invalid prune min age, zero or positive number expected")
+		return errors.New("invalid prune-min-age")
+	}
+	for _, dir := range protected {
+		if len(dir) == 0 {
+			log.Errorln("Invalid protected directory")
+			return errors.New("invalid prune-protect")
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestDirPrunerPrune(t *testing.T) {
+	tests := []struct {
+		name      string
+		relative  bool
+		protected []string
+		dryRun    bool
+		// removed are the files removed by the run, the other files are kept
+		removed []string
+		kept    []string
+		// wantDirs are the directories expected after pruning
+		wantDirs    []string
+		wantRemoved []string
+	}{
+		{
+			name:        "absolute root",
+			removed:     []string{"sub/deep/a.log", "sub/b.log"},
+			wantRemoved: []string{"sub/deep", "sub"},
+		},
+		{
+			name:        "relative root",
+			relative:    true,
+			removed:     []string{"sub/deep/a.log", "sub/b.log"},
+			wantRemoved: []string{"sub/deep", "sub"},
+		},
+		{
+			name:        "directory not empty",
+			relative:    true,
+			removed:     []string{"sub/deep/a.log"},
+			kept:        []string{"sub/b.log"},
+			wantDirs:    []string{"sub"},
+			wantRemoved: []string{"sub/deep"},
+		},
+		{
+			name:        "protected directory",
+			relative:    true,
+			protected:   []string{"sub"},
+			removed:     []string{"sub/deep/a.log", "sub/b.log"},
+			wantDirs:    []string{"sub"},
+			wantRemoved: []string{"sub/deep"},
+		},
+		{
+			name:     "dry run",
+			relative: true,
+			dryRun:   true,
+			removed:  []string{"sub/deep/a.log", "sub/b.log"},
+			wantDirs: []string{"sub/deep", "sub"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range append(append([]string{}, test.removed...), test.kept...) {
+				if err := os.MkdirAll(path.Join(dir, path.Dir(name)), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path.Join(dir, name), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			rootDir := dir
+			if test.relative {
+				chdir(t, dir)
+				rootDir = "."
+			}
+			var protected []string
+			for _, name := range test.protected {
+				protected = append(protected, path.Join(rootDir, name))
+			}
+
+			pruner := newDirPruner(rootDir, 0, protected, test.dryRun)
+			for _, name := range test.removed {
+				filePath := path.Join(rootDir, name)
+				pruner.trackDir(path.Dir(filePath))
+				if !test.dryRun {
+					if err := os.Remove(filePath); err != nil {
+						t.Fatal(err)
+					}
+				}
+				pruner.markRemoved(filePath)
+			}
+			if err := pruner.prune(); err != nil {
+				t.Fatalf("prune() error: %v", err)
+			}
+
+			if _, err := os.Stat(dir); err != nil {
+				t.Errorf("root directory removed: %v", err)
+			}
+			for _, name := range test.wantDirs {
+				if _, err := os.Stat(path.Join(dir, name)); err != nil {
+					t.Errorf("directory %v removed: %v", name, err)
+				}
+			}
+			for _, name := range test.wantRemoved {
+				if _, err := os.Stat(path.Join(dir, name)); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("directory %v not removed: %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+// chdir changes the working directory for the duration of the test
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := os.Chdir(wd); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
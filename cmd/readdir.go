@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+)
+
+// defaultBatchSize is the number of directory entries read at a time
+const defaultBatchSize = 1000
+
+// errStopReading is returned by the readDirectory callbacks to stop reading early
+var errStopReading = errors.New("stop reading")
+
+// scanOptions controls how the entries of huge directories are read
+type scanOptions struct {
+	batchSize     int
+	progressEvery int
+}
+
+// scanProgress counts the entries scanned under a directory, logging the progress every given number of entries
+type scanProgress struct {
+	dirName string
+	every   int
+	entries int
+}
+
+func newScanProgress(dirName string, opts scanOptions) *scanProgress {
+	return &scanProgress{dirName: dirName, every: opts.progressEvery}
+}
+
+func (p *scanProgress) add() {
+	p.entries++
+	if p.every > 0 && p.entries%p.every == 0 {
+		log.Infof("Scanned %d files of directory %v", p.entries, p.dirName)
+	}
+}
+
+// readDirectory reads the entries of a directory in batches of batchSize, so that
+// the memory used does not depend on the number of files in the directory
+func readDirectory(dirName string, batchSize int, fn func(fileInfo os.FileInfo) error) error {
+	directory, err := os.Open(dirName)
+	if err != nil {
+		return err
+	}
+	defer func(directory *os.File) {
+		err := directory.Close()
+		if err != nil {
+			log.Warnln("Error closing directory", dirName, err.Error())
+		}
+	}(directory)
+
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	for {
+		entries, err := directory.Readdir(batchSize)
+		for _, fileInfo := range entries {
+			if err := fn(fileInfo); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func checkScanOptions(opts scanOptions) error {
+	if opts.batchSize <= 0 {
+		log.Errorln("Invalid batch size, positive number expected")
+		return errors.New("invalid batch-size")
+	}
+	if opts.progressEvery < 0 {
+		log.Errorln("Invalid progress interval, zero or positive number expected")
+		return errors.New("invalid progress-every")
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// renameNoReplace renames the file, failing when the destination exists. The check is atomic
+// unless the file system does not support it, then the file is renamed with a hard link.
+func renameNoReplace(fromFile string, toFile string) error {
+	err := unix.Renameat2(unix.AT_FDCWD, fromFile, unix.AT_FDCWD, toFile, unix.RENAME_NOREPLACE)
+	if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOSYS) {
+		return linkNoReplace(fromFile, toFile)
+	}
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: fromFile, New: toFile, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package cmd
+
+// renameNoReplace renames the file with a hard link, failing when the destination exists
+func renameNoReplace(fromFile string, toFile string) error {
+	return linkNoReplace(fromFile, toFile)
+}
@@ -14,6 +14,8 @@ func init() {
 	RootCmd.AddCommand(MatchCmd)
 	RootCmd.AddCommand(WatchCmd)
 	RootCmd.AddCommand(FreeSpaceCmd)
+	RootCmd.AddCommand(TrashCmd)
+	RootCmd.AddCommand(ApplyCmd)
 }
 
 func Execute() error {
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+)
+
+// fileTemplate computes a value from the attributes of a file, like its destination path
+// relative to the destination directory or the arguments of a command
+type fileTemplate struct {
+	text     string
+	template *template.Template
+}
+
+// fileTemplateData holds the values available to file templates
+type fileTemplateData struct {
+	// Path is the full path of the file
+	Path   string
+	Name   string
+	Base   string
+	Ext    string
+	Dir    string
+	Time   time.Time
+	Year   string
+	Month  string
+	Day    string
+	Hour   string
+	Minute string
+	// Groups holds the full match and the capture groups of the matching pattern
+	Groups []string
+}
+
+func newFileTemplate(text string) (*fileTemplate, error) {
+	if len(text) == 0 {
+		return nil, nil
+	}
+	tmpl, err := template.New("file").Option("missingkey=error").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &fileTemplate{text: text, template: tmpl}, nil
+}
+
+// newFileTemplateData describes the file dirName/fileName, using fileTime for the date fields
+func newFileTemplateData(baseDir, dirName, fileName string, fileTime time.Time, groups []string) fileTemplateData {
+	ext := path.Ext(fileName)
+	return fileTemplateData{
+		Path:   path.Join(dirName, fileName),
+		Name:   fileName,
+		Base:   strings.TrimSuffix(fileName, ext),
+		Ext:    ext,
+		Dir:    relativePath(baseDir, dirName),
+		Time:   fileTime,
+		Year:   fileTime.Format("2006"),
+		Month:  fileTime.Format("01"),
+		Day:    fileTime.Format("02"),
+		Hour:   fileTime.Format("15"),
+		Minute: fileTime.Format("04"),
+		Groups: groups,
+	}
+}
+
+// render returns the text produced by the template
+func (t *fileTemplate) render(data fileTemplateData) (string, error) {
+	var out bytes.Buffer
+	if err := t.template.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// execute returns the destination path, which must stay inside the destination directory
+func (t *fileTemplate) execute(data fileTemplateData) (string, error) {
+	out, err := t.render(data)
+	if err != nil {
+		return "", err
+	}
+	destName := path.Clean(strings.TrimSpace(out))
+	if destName == "." || destName == ".." || strings.HasPrefix(destName, "../") || path.IsAbs(destName) {
+		return "", fmt.Errorf("invalid destination %q from template %v", out, t.text)
+	}
+	return destName, nil
+}
+
+// templateFuncs are the functions available to file templates
+var templateFuncs = template.FuncMap{
+	"replace":   replacePattern,
+	"lower":     strings.ToLower,
+	"upper":     strings.ToUpper,
+	"timestamp": formatTimestamp,
+	"now":       time.Now,
+	"sanitize":  sanitizeName,
+}
+
+// replacePattern replaces the matches of the regular expression in the text, expanding
+// the capture groups referenced in the replacement (e.g. ${1})
+func replacePattern(pattern, replacement, text string) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+	return re.ReplaceAllString(text, replacement), nil
+}
+
+// formatTimestamp formats the time with the Go layout
+func formatTimestamp(layout string, t time.Time) string {
+	return t.Format(layout)
+}
+
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// sanitizeName removes the accents from the name and replaces spaces and any other
+// character except ASCII letters, digits, dots, dashes and underscores with an underscore
+func sanitizeName(name string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	if plain, _, err := transform.String(t, name); err == nil {
+		name = plain
+	}
+	return unsafeNameChars.ReplaceAllString(name, "_")
+}
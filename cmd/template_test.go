@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFileTemplateExecute(t *testing.T) {
+	fileTime := time.Date(2024, time.March, 5, 14, 30, 0, 0, time.Local)
+	data := newFileTemplateData("/data", "/data/in", "report.csv", fileTime, []string{"report.csv", "report"})
+
+	tests := []struct {
+		name    string
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{name: "file name", text: "{{.Name}}", want: "report.csv"},
+		{name: "date directories", text: "{{.Year}}/{{.Month}}/{{.Day}}/{{.Name}}", want: "2024/03/05/report.csv"},
+		{name: "capture group", text: "{{index .Groups 1}}{{.Ext}}", want: "report.csv"},
+		{name: "relative directory", text: "{{.Dir}}/{{.Base}}.old", want: "in/report.old"},
+		{name: "cleaned path", text: " a/./b//../{{.Name}} ", want: "a/report.csv"},
+		{name: "dot dot inside the directory", text: "a/../{{.Name}}", want: "report.csv"},
+		{name: "parent directory", text: "..", wantErr: true},
+		{name: "escaping the directory", text: "../{{.Name}}", wantErr: true},
+		{name: "escaping after cleaning", text: "a/../../{{.Name}}", wantErr: true},
+		{name: "absolute path", text: "/etc/{{.Name}}", wantErr: true},
+		{name: "absolute path from the file", text: "{{.Path}}", wantErr: true},
+		{name: "current directory", text: "./", wantErr: true},
+		{name: "blank", text: "{{if false}}x{{end}} ", wantErr: true},
+		{name: "missing field", text: "{{.Missing}}", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpl, err := newFileTemplate(test.text)
+			if err != nil {
+				t.Fatalf("newFileTemplate(%q) error: %v", test.text, err)
+			}
+			got, err := tmpl.execute(data)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("execute(%q) = %q, want error", test.text, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("execute(%q) error: %v", test.text, err)
+			}
+			if got != test.want {
+				t.Errorf("execute(%q) = %q, want %q", test.text, got, test.want)
+			}
+		})
+	}
+}
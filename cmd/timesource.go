@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// timeSource selects the timestamp of a file used for age decisions: the
+// modification, access, status change or birth time, or a date in the file name
+type timeSource struct {
+	kind    string
+	pattern *regexp.Regexp
+	layout  string
+}
+
+func newTimeSource(kind, pattern, layout string) (timeSource, error) {
+	ts := timeSource{kind: strings.ToLower(kind), layout: layout}
+	switch ts.kind {
+	case "":
+		ts.kind = "mtime"
+	case "mtime", "atime", "ctime", "birth":
+	case "name":
+		if len(pattern) == 0 {
+			log.Errorln("Missing time pattern for the name time source")
+			return ts, errors.New("missing time pattern")
+		}
+		if len(layout) == 0 {
+			log.Errorln("Missing time layout for the name time source")
+			return ts, errors.New("missing time layout")
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Errorln("Invalid time pattern regexp", pattern, err.Error())
+			return ts, err
+		}
+		ts.pattern = re
+	default:
+		log.Errorln("Invalid time source", kind)
+		return ts, errors.New("invalid time source")
+	}
+	return ts, nil
+}
+
+func (ts timeSource) String() string {
+	switch ts.kind {
+	case "atime":
+		return "access time"
+	case "ctime":
+		return "status change time"
+	case "birth":
+		return "birth time"
+	case "name":
+		return "file name date"
+	}
+	return "modification time"
+}
+
+// fileTime returns the timestamp of the file according to the time source
+func (ts timeSource) fileTime(filePath string, fileInfo os.FileInfo) (time.Time, error) {
+	switch ts.kind {
+	case "", "mtime":
+		return fileInfo.ModTime(), nil
+	case "name":
+		fileTime, ok := parseFileNameDate(fileInfo.Name(), ts.pattern, ts.layout)
+		if !ok {
+			return time.Time{}, fmt.Errorf("no valid date in file name %v", fileInfo.Name())
+		}
+		return fileTime, nil
+	default:
+		return statFileTime(ts.kind, filePath, fileInfo)
+	}
+}
+
+// exceedsMaxAge reports whether the file is older than maxAge minutes according
+// to the time source. Files whose time cannot be determined are considered too old.
+func exceedsMaxAge(filePath string, fileInfo os.FileInfo, ts timeSource, maxAge int) bool {
+	if maxAge <= 0 {
+		return false
+	}
+	fileTime, err := ts.fileTime(filePath, fileInfo)
+	if err != nil {
+		log.Warnln("Skipping file", fileInfo.Name(), err.Error())
+		return true
+	}
+	return int(time.Now().Sub(fileTime).Minutes()) > maxAge
+}
+
+// parseFileNameDate extracts a date from the first capture group of the pattern
+// (or the whole match if the pattern has no groups) applied to the file name
+func parseFileNameDate(fileName string, pattern *regexp.Regexp, layout string) (time.Time, bool) {
+	match := pattern.FindStringSubmatch(fileName)
+	if match == nil {
+		return time.Time{}, false
+	}
+	value := match[0]
+	if len(match) > 1 {
+		value = match[1]
+	}
+	date, err := time.ParseInLocation(layout, value, time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return date, true
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"os"
+	"syscall"
+	"time"
+)
+
+func statFileTime(kind, filePath string, fileInfo os.FileInfo) (time.Time, error) {
+	if kind == "birth" {
+		var statx unix.Statx_t
+		if err := unix.Statx(unix.AT_FDCWD, filePath, unix.AT_SYMLINK_NOFOLLOW, unix.STATX_BTIME, &statx); err != nil {
+			return time.Time{}, err
+		}
+		if statx.Mask&unix.STATX_BTIME == 0 {
+			return time.Time{}, errors.New("birth time not supported by the file system")
+		}
+		return time.Unix(statx.Btime.Sec, int64(statx.Btime.Nsec)), nil
+	}
+
+	stat, ok := fileInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, errors.New("file status not available")
+	}
+	switch kind {
+	case "atime":
+		return time.Unix(stat.Atim.Unix()), nil
+	case "ctime":
+		return time.Unix(stat.Ctim.Unix()), nil
+	}
+	return time.Time{}, errors.New("invalid time source")
+}
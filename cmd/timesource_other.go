@@ -0,0 +1,13 @@
+//go:build !linux
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+func statFileTime(kind, filePath string, fileInfo os.FileInfo) (time.Time, error) {
+	return time.Time{}, fmt.Errorf("time source %v not supported on this platform", kind)
+}
@@ -0,0 +1,271 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+func init() {
+	TrashCmd.PersistentFlags().StringVar(&trashCmdParams.trashDir, "trash-dir", "", "Trash directory")
+	TrashPurgeCmd.Flags().IntVar(&trashCmdParams.olderThanDays, "older-than", 0, "Only purge files trashed more than the given number of days ago")
+	TrashPurgeCmd.Flags().BoolVar(&trashCmdParams.dryRun, "dry-run", false, "Only list the files to purge without deleting")
+	TrashRestoreCmd.Flags().BoolVar(&trashCmdParams.overwrite, "overwrite", false, "Overwrite the original file if it exists")
+
+	TrashCmd.AddCommand(TrashListCmd)
+	TrashCmd.AddCommand(TrashRestoreCmd)
+	TrashCmd.AddCommand(TrashPurgeCmd)
+}
+
+type trashCmdParamsType struct {
+	trashDir      string
+	olderThanDays int
+	dryRun        bool
+	overwrite     bool
+}
+
+// trashInfo is the metadata stored for every file moved to the trash
+type trashInfo struct {
+	ID           string    `json:"id"`
+	OriginalPath string    `json:"originalPath"`
+	TrashedAt    time.Time `json:"trashedAt"`
+	Rule         string    `json:"rule"`
+	Size         int64     `json:"size"`
+	ModTime      time.Time `json:"modTime"`
+}
+
+var trashCmdParams = trashCmdParamsType{}
+
+var TrashCmd = &cobra.Command{
+	Use:   "trash",
+	Short: "manage files moved to the trash",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return checkTrashDir(trashCmdParams.trashDir, true)
+	},
+}
+
+var TrashListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list trashed files",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		infos, err := listTrash(trashCmdParams.trashDir)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			fmt.Printf("%v\t%v\t%10d bytes\t%v\t%v\n", info.ID, info.TrashedAt.Format(time.RFC3339), info.Size, info.OriginalPath, info.Rule)
+		}
+		return nil
+	},
+}
+
+var TrashRestoreCmd = &cobra.Command{
+	Use:   "restore ID...",
+	Short: "restore trashed files to their original path",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		for _, id := range args {
+			if err := restoreTrashFile(trashCmdParams.trashDir, id, trashCmdParams.overwrite); err != nil {
+				log.Errorf("Error restoring file %v: %v", id, err.Error())
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+var TrashPurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "permanently delete trashed files",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if trashCmdParams.olderThanDays < 0 {
+			log.Errorln("Invalid older than days, zero or positive number expected")
+			return errors.New("invalid older-than")
+		}
+		return purgeTrash(trashCmdParams.trashDir, trashCmdParams.olderThanDays, trashCmdParams.dryRun)
+	},
+}
+
+// trashFile moves a file in the trash directory, saving the metadata needed to restore it
+func trashFile(trashDir, filePath, rule string) error {
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Join(trashDir, "files"), 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Join(trashDir, "info"), 0755); err != nil {
+		return err
+	}
+
+	info := trashInfo{
+		OriginalPath: absPath,
+		TrashedAt:    time.Now(),
+		Rule:         rule,
+		Size:         fileInfo.Size(),
+		ModTime:      fileInfo.ModTime(),
+	}
+
+	// The info file is created exclusively to reserve the id
+	baseID := info.TrashedAt.Format("20060102-150405") + "-" + fileInfo.Name()
+	var infoFile *os.File
+	for i := 0; ; i++ {
+		info.ID = baseID
+		if i > 0 {
+			info.ID = fmt.Sprintf("%v.%d", baseID, i)
+		}
+		infoFile, err = os.OpenFile(trashInfoPath(trashDir, info.ID), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+	}
+
+	err = json.NewEncoder(infoFile).Encode(info)
+	if closeErr := infoFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = moveFile(filePath, trashFilePath(trashDir, info.ID))
+	}
+	if err != nil {
+		if err := os.Remove(trashInfoPath(trashDir, info.ID)); err != nil {
+			log.Warnln("Error removing trash info", info.ID, err.Error())
+		}
+		return err
+	}
+	return nil
+}
+
+func listTrash(trashDir string) ([]trashInfo, error) {
+	entries, err := os.ReadDir(path.Join(trashDir, "info"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]trashInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		info, err := readTrashInfo(path.Join(trashDir, "info", entry.Name()))
+		if err != nil {
+			log.Warnln("Invalid trash info", entry.Name(), err.Error())
+			continue
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].TrashedAt.Before(infos[j].TrashedAt)
+	})
+	return infos, nil
+}
+
+func restoreTrashFile(trashDir, id string, overwrite bool) error {
+	info, err := readTrashInfo(trashInfoPath(trashDir, id))
+	if err != nil {
+		return err
+	}
+	if !overwrite {
+		if _, err := os.Lstat(info.OriginalPath); err == nil {
+			return fmt.Errorf("file %v already exists", info.OriginalPath)
+		}
+	}
+	if err := os.MkdirAll(path.Dir(info.OriginalPath), 0755); err != nil {
+		return err
+	}
+
+	log.Infof("Restoring file %v to %v", info.ID, info.OriginalPath)
+	if err := moveFile(trashFilePath(trashDir, id), info.OriginalPath); err != nil {
+		return err
+	}
+	return os.Remove(trashInfoPath(trashDir, id))
+}
+
+// purgeTrash permanently deletes the files trashed more than olderThanDays days ago
+func purgeTrash(trashDir string, olderThanDays int, dryRun bool) error {
+	infos, err := listTrash(trashDir)
+	if err != nil {
+		return err
+	}
+
+	startDate := time.Now().AddDate(0, 0, -olderThanDays)
+	for _, info := range infos {
+		if !info.TrashedAt.Before(startDate) {
+			continue
+		}
+		trashAge := time.Now().Sub(info.TrashedAt).Hours() / 24
+		if dryRun {
+			log.Infof("Candidate trash file %-30v\t%10d bytes\t%v\t%.0f days old", info.ID, info.Size, info.TrashedAt.Format(time.RFC3339), trashAge)
+			continue
+		}
+		log.Infof("Purging %-30v\t%10d bytes\t%v\t%.0f days old", info.ID, info.Size, info.TrashedAt.Format(time.RFC3339), trashAge)
+		if err := os.Remove(trashFilePath(trashDir, info.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Errorln("Impossible to purge file", info.ID)
+			return err
+		}
+		if err := os.Remove(trashInfoPath(trashDir, info.ID)); err != nil {
+			log.Errorln("Impossible to purge file info", info.ID)
+			return err
+		}
+	}
+	return nil
+}
+
+func readTrashInfo(infoPath string) (trashInfo, error) {
+	var info trashInfo
+	content, err := os.ReadFile(infoPath)
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(content, &info)
+	return info, err
+}
+
+func trashFilePath(trashDir, id string) string {
+	return path.Join(trashDir, "files", filepath.Base(id))
+}
+
+func trashInfoPath(trashDir, id string) string {
+	return path.Join(trashDir, "info", filepath.Base(id)+".json")
+}
+
+func checkTrashDir(trashDir string, required bool) error {
+	if len(trashDir) == 0 {
+		if required {
+			log.Errorln("Missing trash directory")
+			return errors.New("missing trash directory")
+		}
+		return nil
+	}
+	dirInfo, err := os.Stat(trashDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		log.Errorln("Invalid trash directory", err)
+		return err
+	}
+	if !dirInfo.IsDir() {
+		log.Errorln("Trash directory must be a valid directory")
+		return errors.New("invalid trash directory")
+	}
+	return nil
+}
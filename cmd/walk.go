@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+type walkOptions struct {
+	recursive      bool
+	maxDepth       int
+	includeDirs    []string
+	excludeDirs    []string
+	followSymlinks bool
+	// skipDirs holds the real paths of the directories never visited
+	skipDirs []string
+	// continueOnError skips the subdirectories that cannot be read, reporting their errors at the end of the walk
+	continueOnError bool
+	scanOptions
+}
+
+// walkState is shared by all the levels of a directory walk
+type walkState struct {
+	visited  map[string]bool
+	progress *scanProgress
+	// dirErrors collects the errors of the subdirectories skipped with continueOnError
+	dirErrors []error
+}
+
+// dirReadError is the error reading the content of a directory, as opposed to the
+// errors returned by the walk function
+type dirReadError struct {
+	err error
+}
+
+func (e *dirReadError) Error() string {
+	return e.err.Error()
+}
+
+func (e *dirReadError) Unwrap() error {
+	return e.err
+}
+
+// walkFunc is called for every regular file found while walking a directory tree
+type walkFunc func(dirName string, fileInfo os.FileInfo) error
+
+// walkDirectory visits the files inside rootDir, descending into subdirectories
+// when the recursive option is enabled. Symlinked directories are only followed
+// when explicitly requested, and each real directory is visited only once.
+// With continueOnError the unreadable subdirectories are skipped and their errors
+// are returned together once the walk is complete.
+func walkDirectory(rootDir string, opts walkOptions, fn walkFunc) error {
+	state := &walkState{visited: map[string]bool{}, progress: newScanProgress(rootDir, opts.scanOptions)}
+	if realDir, err := filepath.EvalSymlinks(rootDir); err == nil {
+		state.visited[realDir] = true
+	}
+	err := walkDirectoryLevel(rootDir, 0, opts, state, fn)
+	if len(state.dirErrors) == 0 {
+		return err
+	}
+	if err != nil {
+		state.dirErrors = append(state.dirErrors, err)
+	}
+	return multiError(state.dirErrors)
+}
+
+func walkDirectoryLevel(dirName string, depth int, opts walkOptions, state *walkState, fn walkFunc) error {
+	fnFailed := false
+	err := readDirectory(dirName, opts.batchSize, func(fileInfo os.FileInfo) error {
+		err := walkEntry(dirName, fileInfo, depth, opts, state, fn)
+		fnFailed = err != nil
+		return err
+	})
+	if err != nil && !fnFailed {
+		return &dirReadError{err: err}
+	}
+	return err
+}
+
+// walkEntry calls the walk function on a file, or descends into a subdirectory
+func walkEntry(dirName string, fileInfo os.FileInfo, depth int, opts walkOptions, state *walkState, fn walkFunc) error {
+	state.progress.add()
+	fileName := fileInfo.Name()
+	isDir := fileInfo.IsDir()
+
+	if fileInfo.Mode()&fs.ModeSymlink != 0 {
+		if !opts.followSymlinks {
+			log.Infoln("Skipping symlink", fileName)
+			return nil
+		}
+		targetInfo, err := os.Stat(path.Join(dirName, fileName))
+		if err != nil || !targetInfo.IsDir() {
+			log.Infoln("Skipping symlink", fileName)
+			return nil
+		}
+		isDir = true
+	}
+
+	if !isDir {
+		return fn(dirName, fileInfo)
+	}
+
+	if !opts.recursive {
+		log.Infoln("Skipping directory", fileName)
+		return nil
+	}
+	if opts.maxDepth > 0 && depth >= opts.maxDepth {
+		log.Debugln("Skipping directory", fileName, "max depth reached")
+		return nil
+	}
+	if !isDirIncluded(fileName, opts) {
+		log.Infoln("Skipping excluded directory", fileName)
+		return nil
+	}
+
+	subDir := path.Join(dirName, fileName)
+	realDir, err := filepath.EvalSymlinks(subDir)
+	if err != nil {
+		log.Warnln("Error resolving directory", subDir, err.Error())
+		return nil
+	}
+	if contains(opts.skipDirs, realDir) {
+		log.Infoln("Skipping destination directory", subDir)
+		return nil
+	}
+	if state.visited[realDir] {
+		log.Infoln("Skipping already visited directory", subDir)
+		return nil
+	}
+	state.visited[realDir] = true
+
+	log.Debugln("Entering directory", subDir)
+	err = walkDirectoryLevel(subDir, depth+1, opts, state, fn)
+	var readErr *dirReadError
+	if opts.continueOnError && errors.As(err, &readErr) {
+		log.Errorln("Skipping unreadable directory", subDir, readErr.err.Error())
+		state.dirErrors = append(state.dirErrors, err)
+		return nil
+	}
+	return err
+}
+
+func isDirIncluded(dirName string, opts walkOptions) bool {
+	for _, glob := range opts.excludeDirs {
+		if match, _ := filepath.Match(glob, dirName); match {
+			return false
+		}
+	}
+	if len(opts.includeDirs) == 0 {
+		return true
+	}
+	for _, glob := range opts.includeDirs {
+		if match, _ := filepath.Match(glob, dirName); match {
+			return true
+		}
+	}
+	return false
+}
+
+func checkWalkOptions(opts walkOptions) error {
+	if err := checkScanOptions(opts.scanOptions); err != nil {
+		return err
+	}
+	if opts.maxDepth < 0 {
+		log.Errorln("Invalid max depth, zero or positive number expected")
+		return errors.New("invalid max-depth")
+	}
+	for _, glob := range append(append([]string{}, opts.includeDirs...), opts.excludeDirs...) {
+		if _, err := filepath.Match(glob, ""); err != nil {
+			log.Errorln("Invalid directory glob", glob, err.Error())
+			return err
+		}
+	}
+	return nil
+}
@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"errors"
-	"github.com/radovskyb/watcher"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -21,27 +20,68 @@ func init() {
 	WatchCmd.PersistentFlags().StringVarP(&watchCmdParams.configFile, "config", "c", "", "Config file")
 	WatchCmd.Flags().BoolVar(&watchCmdParams.debug, "debug", false, "Enable debug log")
 	WatchCmd.Flags().IntVar(&watchCmdParams.frequency, "frequency", 10, "Watch frequency in seconds")
+	WatchCmd.Flags().IntVar(&watchCmdParams.batchSize, "batch-size", defaultBatchSize, "Number of directory entries read at a time")
+	WatchCmd.Flags().IntVar(&watchCmdParams.progressEvery, "progress-every", 0, "Log the progress of the initial scan every given number of files (0 means never)")
 }
 
 type WatchCmdParamsType struct {
 	configFile string
 	debug      bool
 	frequency  int
+	scanOptions
 }
 type DirWatchConfig struct {
 	Name  string
 	Rules []RuleConfig
 }
 type RuleConfig struct {
+	Action              string
+	Destination         string
+	DestinationTemplate string
+	RenameTemplate      string
+	LatestLink          string
+	Mode                string
+	Owner               string
+	Group               string
+	Conflict            string
+	Verify              string
+	ChecksumFile        bool
+	Preserve            []string
+	Compression         string
+	MaxExtractSize      string
+	Command             string
+	Args                []string
+	Timeout             int
+	OnSuccess           FollowUpConfig
+	OnFailure           FollowUpConfig
+	preserveOpts        preserveOptions
+	Prefix              []string
+	Pattern             []string
+	Suffix              []string
+	MaxAge              int
+	TimeSource          string
+	TimePattern         string
+	TimeLayout          string
+	timeSource          timeSource
+	destTemplate        *fileTemplate
+	commandArgs         []*fileTemplate
+	renameTemplate      *fileTemplate
+	permissions         permissionOptions
+	maxExtractBytes     uint64
+}
+
+// FollowUpConfig is the action executed on the file after the command of an EXEC rule
+type FollowUpConfig struct {
 	Action      string
 	Destination string
-	Prefix      []string
-	Pattern     []string
-	Suffix      []string
 }
 type WatchConfig struct {
-	DryRun      bool
-	Directories []DirWatchConfig
+	DryRun         bool
+	TrashDir       string
+	TrashRetention int
+	Directories    []DirWatchConfig
+	// renamed collects the new paths of the files renamed by the rules, not to process them again
+	renamed map[string]bool
 }
 
 var watchCmdParams = WatchCmdParamsType{}
@@ -53,6 +93,9 @@ var WatchCmd = &cobra.Command{
 		if watchCmdParams.debug {
 			log.SetLevel(log.DebugLevel)
 		}
+		if err := checkScanOptions(watchCmdParams.scanOptions); err != nil {
+			return err
+		}
 		if len(watchCmdParams.configFile) == 0 {
 			log.Errorln("Invalid config file name")
 			return errors.New("invalid config file")
@@ -96,8 +139,20 @@ func initConfig(configFile string) (*WatchConfig, error) {
 	}
 
 	outConfig := WatchConfig{
-		DryRun:      config.Watch.DryRun,
-		Directories: make([]DirWatchConfig, len(config.Watch.Directories)),
+		DryRun:         config.Watch.DryRun,
+		TrashRetention: config.Watch.TrashRetention,
+		Directories:    make([]DirWatchConfig, len(config.Watch.Directories)),
+	}
+
+	if len(config.Watch.TrashDir) > 0 {
+		if err := checkTrashDir(config.Watch.TrashDir, false); err != nil {
+			return nil, err
+		}
+		outConfig.TrashDir, _ = filepath.Abs(config.Watch.TrashDir)
+	}
+	if config.Watch.TrashRetention < 0 {
+		log.Errorln("Invalid trash retention, zero or positive number expected")
+		return nil, errors.New("invalid trash retention")
 	}
 
 	for i, d := range config.Watch.Directories {
@@ -126,8 +181,9 @@ func initConfig(configFile string) (*WatchConfig, error) {
 			rule.Action = strings.ToUpper(configRule.Action)
 			dirWatchRule.Action = rule.Action
 			switch rule.Action {
-			case "COPY", "MOVE", "COPY-DELETE":
-				if len(configRule.Destination) == 0 {
+			case "COPY", "MOVE", "COPY-DELETE", "COMPRESS", "EXTRACT", "LINK", "HARDLINK":
+				// Without destination files are compressed and extracted in place
+				if len(configRule.Destination) == 0 && rule.Action != "COMPRESS" && rule.Action != "EXTRACT" {
 					log.Errorln("Missing destination directory")
 					return nil, errors.New("missing destination directory")
 				}
@@ -143,6 +199,102 @@ func initConfig(configFile string) (*WatchConfig, error) {
 						log.Warnln("Error closing destination directory", err.Error())
 					}
 				}
+				if err := checkConflictPolicy(configRule.Conflict); err != nil {
+					return nil, err
+				}
+				dirWatchRule.Conflict = configRule.Conflict
+				if len(dirWatchRule.Conflict) == 0 {
+					dirWatchRule.Conflict = defaultConflictPolicy
+				}
+				if err := checkLatestLink(configRule.LatestLink); err != nil {
+					return nil, err
+				}
+				dirWatchRule.LatestLink = configRule.LatestLink
+				dirWatchRule.Verify = configRule.Verify
+				dirWatchRule.ChecksumFile = configRule.ChecksumFile
+				if err := checkChecksumOptions(checksumOptions{verify: configRule.Verify}); err != nil {
+					return nil, err
+				}
+				dirWatchRule.Preserve = configRule.Preserve
+				dirWatchRule.preserveOpts, err = newPreserveOptions(configRule.Preserve)
+				if err != nil {
+					return nil, err
+				}
+				if err := checkCompression(configRule.Compression); err != nil {
+					return nil, err
+				}
+				dirWatchRule.Compression = configRule.Compression
+				if len(dirWatchRule.Compression) == 0 {
+					dirWatchRule.Compression = defaultCompression
+				}
+				dirWatchRule.MaxExtractSize = configRule.MaxExtractSize
+				dirWatchRule.maxExtractBytes, err = parseMaxExtractSize(configRule.MaxExtractSize)
+				if err != nil {
+					return nil, err
+				}
+				dirWatchRule.DestinationTemplate = configRule.DestinationTemplate
+				dirWatchRule.destTemplate, err = newFileTemplate(configRule.DestinationTemplate)
+				if err != nil {
+					log.Errorln("Invalid destination template", configRule.DestinationTemplate, err.Error())
+					return nil, err
+				}
+
+			case "EXEC":
+				dirWatchRule.Timeout = configRule.Timeout
+				if dirWatchRule.Timeout == 0 {
+					dirWatchRule.Timeout = defaultCommandTimeout
+				}
+				if err := checkExecOptions(configRule.Command, configRule.Args, dirWatchRule.Timeout); err != nil {
+					return nil, err
+				}
+				dirWatchRule.Command = configRule.Command
+				dirWatchRule.Args = configRule.Args
+				dirWatchRule.commandArgs, _ = newCommandArgs(configRule.Args)
+				if dirWatchRule.OnSuccess, err = checkFollowUpConfig(configRule.OnSuccess); err != nil {
+					return nil, err
+				}
+				if dirWatchRule.OnFailure, err = checkFollowUpConfig(configRule.OnFailure); err != nil {
+					return nil, err
+				}
+				if err := checkConflictPolicy(configRule.Conflict); err != nil {
+					return nil, err
+				}
+				dirWatchRule.Conflict = configRule.Conflict
+				if len(dirWatchRule.Conflict) == 0 {
+					dirWatchRule.Conflict = defaultConflictPolicy
+				}
+				if err := checkCompression(configRule.Compression); err != nil {
+					return nil, err
+				}
+				dirWatchRule.Compression = configRule.Compression
+				if len(dirWatchRule.Compression) == 0 {
+					dirWatchRule.Compression = defaultCompression
+				}
+
+			case "RENAME":
+				if len(configRule.RenameTemplate) == 0 {
+					log.Errorln("Missing rename template")
+					return nil, errors.New("missing rename template")
+				}
+				dirWatchRule.RenameTemplate = configRule.RenameTemplate
+				dirWatchRule.renameTemplate, err = newFileTemplate(configRule.RenameTemplate)
+				if err != nil {
+					log.Errorln("Invalid rename template", configRule.RenameTemplate, err.Error())
+					return nil, err
+				}
+				if err := checkConflictPolicy(configRule.Conflict); err != nil {
+					return nil, err
+				}
+				dirWatchRule.Conflict = configRule.Conflict
+				if len(dirWatchRule.Conflict) == 0 {
+					dirWatchRule.Conflict = defaultRenameConflictPolicy
+				}
+
+			case "PERMISSIONS":
+				if len(configRule.Mode) == 0 && len(configRule.Owner) == 0 && len(configRule.Group) == 0 {
+					log.Errorln("At least one of mode, owner or group must be configured")
+					return nil, errors.New("missing permissions")
+				}
 
 			case "DELETE":
 			default:
@@ -150,6 +302,18 @@ func initConfig(configFile string) (*WatchConfig, error) {
 				return nil, errors.New("invalid action")
 			}
 
+			// Checking the permissions set on the processed files
+			if err := checkPermissionsAction(rule.Action, configRule.Mode, configRule.Owner, configRule.Group); err != nil {
+				return nil, err
+			}
+			dirWatchRule.Mode = configRule.Mode
+			dirWatchRule.Owner = configRule.Owner
+			dirWatchRule.Group = configRule.Group
+			dirWatchRule.permissions, err = newPermissionOptions(configRule.Mode, configRule.Owner, configRule.Group)
+			if err != nil {
+				return nil, err
+			}
+
 			// Checking matcher presence
 			if len(configRule.Prefix) == 0 && len(configRule.Suffix) == 0 && len(configRule.Pattern) == 0 {
 				log.Errorln("At least one Prefix or Suffix or Pattern must be configured")
@@ -158,6 +322,23 @@ func initConfig(configFile string) (*WatchConfig, error) {
 			dirWatchRule.Prefix = configRule.Prefix
 			dirWatchRule.Suffix = configRule.Suffix
 
+			// Checking max age and time source
+			if configRule.MaxAge < 0 {
+				log.Errorln("Max Age cannot must be greather than zero")
+				return nil, errors.New("invalid max age")
+			}
+			dirWatchRule.MaxAge = configRule.MaxAge
+			dirWatchRule.TimeSource = configRule.TimeSource
+			dirWatchRule.TimePattern = configRule.TimePattern
+			dirWatchRule.TimeLayout = configRule.TimeLayout
+			if len(dirWatchRule.TimeLayout) == 0 {
+				dirWatchRule.TimeLayout = "2006-01-02"
+			}
+			dirWatchRule.timeSource, err = newTimeSource(dirWatchRule.TimeSource, dirWatchRule.TimePattern, dirWatchRule.TimeLayout)
+			if err != nil {
+				return nil, err
+			}
+
 			// Checking pattern validity
 			if len(configRule.Pattern) > 0 {
 				dirWatchRule.Pattern = make([]string, len(configRule.Pattern))
@@ -175,33 +356,36 @@ func initConfig(configFile string) (*WatchConfig, error) {
 	return &outConfig, nil
 }
 
+// checkFollowUpConfig verifies the follow-up action of an EXEC rule, making its destination absolute
+func checkFollowUpConfig(followUp FollowUpConfig) (FollowUpConfig, error) {
+	if err := checkFollowUpAction(followUp.Action, followUp.Destination); err != nil {
+		return followUp, err
+	}
+	followUp.Action = strings.ToUpper(followUp.Action)
+	if len(followUp.Destination) > 0 {
+		followUp.Destination, _ = filepath.Abs(path.Clean(followUp.Destination))
+	}
+	return followUp, nil
+}
+
 func watch(config *WatchConfig) error {
-	w := watcher.New()
-	w.FilterOps(watcher.Create)
-
-	go func(config *WatchConfig) {
-		for {
-			select {
-			case event := <-w.Event:
-				log.Println(event) // Print the event's info.
-				if event.IsDir() {
-					continue
-				}
+	config.renamed = map[string]bool{}
+	if !config.DryRun {
+		cleanRuleTempCopies(config)
+	}
 
-				checkEventMatch(config, event)
-			case err := <-w.Error:
-				log.Errorln(err)
-			case <-w.Closed:
-				return
-			}
-		}
-	}(config)
+	if len(config.TrashDir) > 0 && config.TrashRetention > 0 {
+		go purgeTrashPeriodically(config.TrashDir, config.TrashRetention, config.DryRun)
+	}
 
+	poller := newDirPoller(watchCmdParams.scanOptions)
 	for _, dir := range config.Directories {
-		// Watch this folder for changes.
-		if err := w.Add(dir.Name); err != nil {
+		// Watch this folder for changes, reading the files already in it in batches
+		files, err := poller.add(dir.Name)
+		if err != nil {
 			log.Fatalln(err)
 		}
+		log.Infof("Watching directory %v (%d files)", dir.Name, files)
 	}
 
 	// Start the watching process - it'll check for changes every 10s.
@@ -209,18 +393,26 @@ func watch(config *WatchConfig) error {
 	if frequency <= 0 {
 		frequency = 10
 	}
-	if err := w.Start(time.Second * time.Duration(frequency)); err != nil {
-		log.Fatalln(err)
-	}
+	stop := make(chan struct{})
+	go poller.run(time.Second*time.Duration(frequency), stop, func(event watchEvent) {
+		if config.renamed[event.Path] {
+			// Files renamed by the rules are not new files
+			delete(config.renamed, event.Path)
+			return
+		}
+		log.Infoln("New file", event.Path)
+		checkEventMatch(config, event)
+	})
 
 	doneQuitting := make(chan bool)
 	go func() {
-		quit := make(chan os.Signal)
+		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 		<-quit
 
 		log.Println("Dirkeeper watcher Stopping...")
 
+		close(stop)
 		close(doneQuitting)
 	}()
 	<-doneQuitting
@@ -228,7 +420,37 @@ func watch(config *WatchConfig) error {
 	return nil
 }
 
-func checkEventMatch(config *WatchConfig, event watcher.Event) {
+// purgeTrashPeriodically purges the expired trash files at startup and then every hour
+func purgeTrashPeriodically(trashDir string, retentionDays int, dryRun bool) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if err := purgeTrash(trashDir, retentionDays, dryRun); err != nil {
+			log.Errorln("Error purging trash", trashDir, err.Error())
+		}
+		<-ticker.C
+	}
+}
+
+// cleanRuleTempCopies removes the partial copies left in the rule destinations by an interrupted run
+func cleanRuleTempCopies(config *WatchConfig) {
+	cleaned := map[string]bool{}
+	for _, dir := range config.Directories {
+		for _, rule := range dir.Rules {
+			for _, destDir := range []string{rule.Destination, rule.OnSuccess.Destination, rule.OnFailure.Destination} {
+				if len(destDir) == 0 || cleaned[destDir] {
+					continue
+				}
+				cleaned[destDir] = true
+				if err := cleanTempCopies(destDir, rule.destTemplate != nil); err != nil {
+					log.Warnln("Error removing incomplete copies", destDir, err.Error())
+				}
+			}
+		}
+	}
+}
+
+func checkEventMatch(config *WatchConfig, event watchEvent) {
 	directory, fileName := filepath.Split(event.Path)
 	directory = path.Clean(directory)
 
@@ -241,43 +463,85 @@ func checkEventMatch(config *WatchConfig, event watcher.Event) {
 			log.Infoln("Skipping directory", fileName)
 			return
 		}
-		if event.Mode() == fs.ModeSymlink {
+		if event.Mode()&fs.ModeSymlink != 0 {
 			log.Infoln("Skipping symlink", fileName)
 			return
 		}
+		if isTempCopy(fileName) {
+			log.Debugln("Skipping incomplete copy", fileName)
+			return
+		}
 
 		for _, rule := range dirConfig.Rules {
+			if exceedsMaxAge(event.Path, event, rule.timeSource, rule.MaxAge) {
+				continue
+			}
 			for _, prefix := range rule.Prefix {
 				if strings.HasPrefix(fileName, prefix) {
 					log.Infoln("File", fileName, "matches prefix", prefix)
-					if !config.DryRun {
-						if err := processFile(rule.Action, dirConfig.Name, rule.Destination, event.Name()); err != nil {
-							log.Errorf("Error processing file %v: %v", fileName, err.Error())
-						}
-					}
+					processWatchedFile(config, dirConfig.Name, rule, event, "watch prefix "+prefix, []string{fileName})
 				}
 			}
 			for _, suffix := range rule.Suffix {
 				if strings.HasSuffix(fileName, suffix) {
 					log.Infoln("File", fileName, "matches suffix", suffix)
-					if !config.DryRun {
-						if err := processFile(rule.Action, dirConfig.Name, rule.Destination, event.Name()); err != nil {
-							log.Errorf("Error processing file %v: %v", fileName, err.Error())
-						}
-					}
+					processWatchedFile(config, dirConfig.Name, rule, event, "watch suffix "+suffix, []string{fileName})
 				}
 			}
 			for _, pattern := range rule.Pattern {
-				if match, _ := regexp.MatchString(pattern, fileName); match {
+				re, _ := regexp.Compile(pattern)
+				if groups := re.FindStringSubmatch(fileName); groups != nil {
 					log.Infoln("File", fileName, "matches pattern", pattern)
-					if !config.DryRun {
-						if err := processFile(rule.Action, dirConfig.Name, rule.Destination, event.Name()); err != nil {
-							log.Errorf("Error processing file %v: %v", fileName, err.Error())
-						}
-					}
+					processWatchedFile(config, dirConfig.Name, rule, event, "watch pattern "+pattern, groups)
 				}
 			}
 		}
 
 	}
 }
+
+// processWatchedFile executes the action of the rule on a new file, unless in dry run
+func processWatchedFile(config *WatchConfig, dirName string, rule RuleConfig, event watchEvent, ruleName string, groups []string) {
+	if config.DryRun {
+		return
+	}
+	opts := processOptions{trashDir: config.TrashDir, rule: ruleName, conflict: rule.Conflict}
+	opts.checksumOptions = checksumOptions{verify: rule.Verify, checksumFile: rule.ChecksumFile}
+	opts.preserve = rule.preserveOpts
+	opts.compression = rule.Compression
+	opts.maxExtractBytes = rule.maxExtractBytes
+	opts.permissions = rule.permissions
+	opts.renamed = config.renamed
+	if len(rule.LatestLink) > 0 {
+		opts.latestLink = path.Join(rule.Destination, rule.LatestLink)
+	}
+	fileTime, timeErr := rule.timeSource.fileTime(event.Path, event)
+	if timeErr != nil {
+		fileTime = event.ModTime()
+	}
+	data := newFileTemplateData(dirName, dirName, event.Name(), fileTime, groups)
+	var err error
+	switch {
+	case rule.Action == "RENAME":
+		opts.destName, err = rule.renameTemplate.execute(data)
+	case rule.destTemplate != nil:
+		opts.destName, err = rule.destTemplate.execute(data)
+	}
+	if err != nil {
+		log.Errorf("Error processing file %v: %v", event.Name(), err.Error())
+		return
+	}
+	if rule.Action == "EXEC" {
+		opts.exec = execOptions{timeout: time.Duration(rule.Timeout) * time.Second}
+		opts.exec.onSuccess = followUpAction{action: rule.OnSuccess.Action, destDir: rule.OnSuccess.Destination}
+		opts.exec.onFailure = followUpAction{action: rule.OnFailure.Action, destDir: rule.OnFailure.Destination}
+		opts.exec.command, err = renderCommand(rule.Command, rule.commandArgs, data)
+		if err != nil {
+			log.Errorf("Error processing file %v: %v", event.Name(), err.Error())
+			return
+		}
+	}
+	if err := processFile(rule.Action, dirName, rule.Destination, event.Name(), opts); err != nil && !errors.Is(err, errFileSkipped) {
+		log.Errorf("Error processing file %v: %v", event.Name(), err.Error())
+	}
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// taskGroup runs tasks on a bounded number of goroutines, collecting their errors.
+// Unless continueOnError is set, no task is started after the first failure.
+type taskGroup struct {
+	slots           chan struct{}
+	continueOnError bool
+	wg              sync.WaitGroup
+	mutex           sync.Mutex
+	errors          []error
+}
+
+func newTaskGroup(workers int, continueOnError bool) *taskGroup {
+	if workers < 1 {
+		workers = 1
+	}
+	return &taskGroup{slots: make(chan struct{}, workers), continueOnError: continueOnError}
+}
+
+// run waits for a free worker and executes the task on it, returning false
+// when the task was not started because of a previous failure
+func (g *taskGroup) run(task func() error) bool {
+	g.slots <- struct{}{}
+	if !g.continueOnError && g.failed() {
+		<-g.slots
+		return false
+	}
+	g.wg.Add(1)
+	go func() {
+		defer func() {
+			<-g.slots
+			g.wg.Done()
+		}()
+		if err := task(); err != nil {
+			g.mutex.Lock()
+			g.errors = append(g.errors, err)
+			g.mutex.Unlock()
+		}
+	}()
+	return true
+}
+
+// failed reports whether any task completed so far returned an error
+func (g *taskGroup) failed() bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return len(g.errors) > 0
+}
+
+// wait waits for all the tasks to complete and returns their errors
+func (g *taskGroup) wait() []error {
+	g.wg.Wait()
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.errors
+}
+
+// multiError aggregates the errors of a run that continues after failures
+type multiError []error
+
+func (e multiError) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%d errors: %v", len(e), strings.Join(messages, "; "))
+}
+
+// flattenErrors expands the nested multiErrors into a single list
+func flattenErrors(errs []error) []error {
+	var flat []error
+	for _, err := range errs {
+		if nested, ok := err.(multiError); ok {
+			flat = append(flat, flattenErrors(nested)...)
+			continue
+		}
+		flat = append(flat, err)
+	}
+	return flat
+}
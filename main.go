@@ -3,6 +3,7 @@ package main
 import (
 	"dirkeeper/cmd"
 	log "github.com/sirupsen/logrus"
+	"os"
 	"time"
 )
 
@@ -14,6 +15,6 @@ func main() {
 
 	if err := cmd.Execute(); err != nil {
 		log.Errorln("Error executing main command", err)
-		return
+		os.Exit(1)
 	}
 }